@@ -1,87 +1,517 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"mime"
 	"net/http"
-	"go-rag-demo/rag"
+	"net/url"
 	"os"
-	"bytes"
-	"github.com/ledongthuc/pdf"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/ledongthuc/pdf"
+	"go-rag-demo/rag"
 )
 
+type Server struct {
+	collections *rag.Collections
+	store       *rag.Collection // the default collection
+	embedder    rag.Embedder
+	minScore    float64
+	maxTopK     int
 
+	// Chunking strategy used when an upload does not ask for one.
+	chunker      string
+	chunkSize    int
+	chunkOverlap int
 
-type Server struct {
-	store    *rag.InMemoryStore
-	embedder rag.Embedder
-    minScore float64
+	// What to do when an upload reuses a stored document ID.
+	onConflict rag.ConflictPolicy
 }
 
 // Default used in production
 func NewServer() *Server {
-    return NewServerWithEmbedder(rag.NewOpenAIEmbedder())
+	embedder := newEmbedder()
+	cached, err := rag.NewCachedEmbedder(embedder, rag.CacheOptions{
+		Size: envInt("EMBED_CACHE_SIZE"),
+		Dir:  os.Getenv("EMBED_CACHE_DIR"),
+	})
+	if err != nil {
+		log.Fatalf("invalid EMBED_CACHE_DIR: %v", err)
+	}
+	srv := NewServerWithEmbedder(cached)
+	if _, ok := embedder.(*rag.LocalEmbedder); ok {
+		// Local vectors only share vocabulary, so related texts score
+		// lower than with OpenAI.
+		srv.minScore = localMinScore
+	}
+	srv.chunker = os.Getenv("CHUNKER")
+	srv.chunkSize = envInt("CHUNK_SIZE")
+	srv.chunkOverlap = envInt("CHUNK_OVERLAP")
+	if _, err := srv.newChunker("", "", ""); err != nil {
+		log.Fatalf("invalid chunker configuration: %v", err)
+	}
+	policy, err := rag.ParseConflictPolicy(os.Getenv("ON_CONFLICT"))
+	if err != nil {
+		log.Fatalf("invalid ON_CONFLICT: %v", err)
+	}
+	srv.onConflict = policy
+	if v := os.Getenv("MIN_SCORE"); v != "" {
+		minScore, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			log.Fatalf("invalid MIN_SCORE: %v", err)
+		}
+		srv.minScore = minScore
+	}
+	if n := envInt("MAX_TOP_K"); n > 0 {
+		srv.maxTopK = min(n, maxResultWindow)
+	}
+	var hnsw *rag.HNSWOptions
+	switch index := os.Getenv("SEARCH_INDEX"); index {
+	case "", "exact":
+	case "hnsw":
+		hnsw = &rag.HNSWOptions{
+			M:              envInt("HNSW_M"),
+			EfConstruction: envInt("HNSW_EF_CONSTRUCTION"),
+			EfSearch:       envInt("HNSW_EF_SEARCH"),
+		}
+		srv.setCollections(rag.NewCollections(func() rag.VectorStore { return rag.NewHNSWStore(*hnsw) }))
+	default:
+		log.Fatalf("invalid SEARCH_INDEX %q (want exact or hnsw)", index)
+	}
+	if dir := os.Getenv("DATA_DIR"); dir != "" {
+		cols, err := rag.OpenCollections(dir, rag.FileStoreOptions{HNSW: hnsw})
+		if err != nil {
+			log.Fatalf("open store in %s: %v", dir, err)
+		}
+		for _, c := range cols.List() {
+			log.Printf("data_dir=%q collection=%q chunks=%d\n", dir, c.Name, c.ChunkCount)
+		}
+		srv.setCollections(cols)
+	}
+	return srv
+}
+
+// Default MIN_SCORE with EMBEDDER=local.
+const localMinScore = 0.1
+
+// newEmbedder builds the embedder named by EMBEDDER: openai (the default)
+// or local, which needs no key or network.
+func newEmbedder() rag.Embedder {
+	switch name := os.Getenv("EMBEDDER"); name {
+	case "", "openai":
+	case "local":
+		opts := rag.LocalOptions{Dim: envInt("LOCAL_EMBED_DIM")}
+		if path := os.Getenv("LOCAL_EMBED_CORPUS"); path != "" {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				log.Fatalf("invalid LOCAL_EMBED_CORPUS: %v", err)
+			}
+			// One text per paragraph.
+			for _, p := range strings.Split(string(data), "\n\n") {
+				if p = strings.TrimSpace(p); p != "" {
+					opts.Corpus = append(opts.Corpus, p)
+				}
+			}
+		}
+		e := rag.NewLocalEmbedder(opts)
+		// Without a corpus there is no IDF weighting; say so at startup.
+		log.Printf("embedder=%q corpus=%d idf=%t\n", e.Model(), len(opts.Corpus), len(opts.Corpus) > 0)
+		return e
+	default:
+		log.Fatalf("invalid EMBEDDER %q (want openai or local)", name)
+	}
+
+	e := rag.NewOpenAIEmbedder()
+	e.BatchSize = envInt("EMBED_BATCH_SIZE")
+	e.BatchTokens = envInt("EMBED_BATCH_TOKENS")
+	e.MaxRetries = envInt("OPENAI_MAX_RETRIES")
+	e.RequestsPerMinute = envInt("OPENAI_RPM")
+	e.TokensPerMinute = envInt("OPENAI_TPM")
+	if v := os.Getenv("OPENAI_TIMEOUT"); v != "" {
+		timeout, err := time.ParseDuration(v)
+		if err != nil {
+			log.Fatalf("invalid OPENAI_TIMEOUT: %v", err)
+		}
+		e.Timeout = timeout
+	}
+	return e
+}
+
+// envInt reads an integer setting; unset or invalid means 0, the default.
+func envInt(name string) int {
+	n, _ := strconv.Atoi(os.Getenv(name))
+	return n
 }
 
 // Extra constructor for tests
 func NewServerWithEmbedder(e rag.Embedder) *Server {
-    return &Server{
-        store:    rag.NewInMemoryStore(),
-        embedder: e,
-        minScore: 0.4,
-    }
+	srv := &Server{
+		embedder:   e,
+		minScore:   0.4,
+		maxTopK:    defaultMaxTopK,
+		chunker:    rag.DefaultChunker,
+		onConflict: rag.ConflictReject,
+	}
+	srv.setCollections(rag.NewCollections(func() rag.VectorStore { return rag.NewInMemoryStore() }))
+	return srv
+}
+
+func (s *Server) setCollections(cols *rag.Collections) {
+	s.collections = cols
+	s.store, _ = cols.Get(rag.DefaultCollection)
+}
+
+// collection returns the named collection, or the default one for "".
+// It writes a 404 if there is no such collection.
+func (s *Server) collection(w http.ResponseWriter, name string) (*rag.Collection, bool) {
+	if name == "" {
+		return s.store, true
+	}
+	coll, ok := s.collections.Get(name)
+	if !ok {
+		http.Error(w, fmt.Sprintf("%v: %q", rag.ErrCollectionNotFound, name), http.StatusNotFound)
+	}
+	return coll, ok
+}
+
+// newChunker builds the chunking strategy for one upload. Empty values
+// fall back to the server defaults.
+func (s *Server) newChunker(name, size, overlap string) (rag.Chunker, error) {
+	if name == "" {
+		name = s.chunker
+	}
+	opts := rag.ChunkerOptions{Size: s.chunkSize, Overlap: s.chunkOverlap, Embedder: s.embedder}
+	if size != "" {
+		n, err := strconv.Atoi(size)
+		if err != nil {
+			return nil, fmt.Errorf("invalid chunk_size %q", size)
+		}
+		opts.Size = n
+	}
+	if overlap != "" {
+		n, err := strconv.Atoi(overlap)
+		if err != nil {
+			return nil, fmt.Errorf("invalid chunk_overlap %q", overlap)
+		}
+		opts.Overlap = n
+	}
+	return rag.NewChunker(name, opts)
+}
+
+// conflictPolicy returns the policy asked for by an upload, or the server default.
+func (s *Server) conflictPolicy(name string) (rag.ConflictPolicy, error) {
+	if name == "" {
+		return s.onConflict, nil
+	}
+	return rag.ParseConflictPolicy(name)
+}
+
+// storeDocument adds a chunked document and writes the HTTP error if the
+// document ID is taken or the embeddings do not fit the collection. It
+// reports whether the chunks were stored.
+func storeDocument(w http.ResponseWriter, coll *rag.Collection, docID string, policy rag.ConflictPolicy, chunks []rag.Chunk) (replaced int, ok bool) {
+	replaced, err := coll.AddDocument(docID, policy, chunks...)
+	if errors.Is(err, rag.ErrDocumentExists) || errors.Is(err, rag.ErrModelMismatch) || errors.Is(err, rag.ErrDimensionMismatch) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return 0, false
+	}
+	if err != nil {
+		writeFailed(w, "failed to store document", err)
+		return 0, false
+	}
+	return replaced, true
+}
+
+// writeFailed reports a failed write: 404 when the collection was deleted
+// while the request was under way, 500 with msg otherwise.
+func writeFailed(w http.ResponseWriter, msg string, err error) {
+	if errors.Is(err, rag.ErrCollectionNotFound) || errors.Is(err, rag.ErrStoreClosed) {
+		http.Error(w, "collection not found", http.StatusNotFound)
+		return
+	}
+	http.Error(w, msg, http.StatusInternalServerError)
+}
+
+// embedFailed reports an embedding failure: 503 when the embedder is
+// unavailable or too slow and the request may be retried, 502 when it
+// answered with something unusable.
+func embedFailed(w http.ResponseWriter, err error) {
+	log.Printf("error - embedding failed: %v", err)
+	if errors.Is(err, rag.ErrEmbedderUnavailable) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		http.Error(w, "embedding service unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	http.Error(w, "embedding service failed", http.StatusBadGateway)
+}
+
+// rejectDuplicate fails early, before paying for embeddings, when the
+// document ID is taken and the policy rejects duplicates.
+func rejectDuplicate(w http.ResponseWriter, coll *rag.Collection, docID string, policy rag.ConflictPolicy) bool {
+	if policy == rag.ConflictReject && coll.HasDocument(docID) {
+		http.Error(w, fmt.Sprintf("%v: %q", rag.ErrDocumentExists, docID), http.StatusConflict)
+		return true
+	}
+	return false
 }
 
 type PDFReader interface {
-    GetPlainText() (io.Reader, error)
+	GetPlainText() (io.Reader, error)
+}
+
+// PagedPDFReader is a PDFReader that can also extract text page by page,
+// which lets chunks record the page they come from.
+type PagedPDFReader interface {
+	PDFReader
+	NumPage() int
+	PageText(num int) (string, error)
+}
+
+// pdfDocument adds per-page text extraction to pdf.Reader.
+type pdfDocument struct {
+	*pdf.Reader
+	fonts map[string]*pdf.Font // cached across pages, parsing charmaps is slow
+}
+
+func (d *pdfDocument) PageText(num int) (string, error) {
+	p := d.Page(num)
+	for _, name := range p.Fonts() {
+		if _, ok := d.fonts[name]; !ok {
+			f := p.Font(name)
+			d.fonts[name] = &f
+		}
+	}
+	return p.GetPlainText(d.fonts)
 }
 
 var openPDF = func(path string) (*os.File, PDFReader, error) {
-    return pdf.Open(path)
+	f, r, err := pdf.Open(path)
+	if err != nil {
+		return f, nil, err
+	}
+	return f, &pdfDocument{Reader: r, fonts: map[string]*pdf.Font{}}, nil
+}
+
+// readPDFText returns the normalized text of a PDF and, when the reader
+// supports it, the offset in that text where each page starts.
+func readPDFText(rdr PDFReader) (string, []int, error) {
+	paged, ok := rdr.(PagedPDFReader)
+	if !ok {
+		b, err := rdr.GetPlainText()
+		if err != nil {
+			return "", nil, err
+		}
+		var buf bytes.Buffer
+		if _, err := io.Copy(&buf, b); err != nil {
+			return "", nil, err
+		}
+		return normalizePDFText(buf.String()), nil, nil
+	}
+
+	var text strings.Builder
+	pages := make([]int, 0, paged.NumPage())
+	for i := 1; i <= paged.NumPage(); i++ {
+		pageText, err := paged.PageText(i)
+		if err != nil {
+			return "", nil, err
+		}
+		pageText = normalizePDFText(pageText)
+		if text.Len() > 0 && pageText != "" {
+			text.WriteByte(' ')
+		}
+		pages = append(pages, text.Len())
+		text.WriteString(pageText)
+	}
+	return text.String(), pages, nil
+}
+
+// Normalize PDF text a bit: replace newlines with spaces and collapse
+// multiple spaces.
+func normalizePDFText(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// attributesFrom collects "attr.<key>=<value>" parameters as chunk attributes.
+func attributesFrom(values url.Values) map[string]string {
+	var attrs map[string]string
+	for key, vals := range values {
+		name, ok := strings.CutPrefix(key, "attr.")
+		if !ok || name == "" || len(vals) == 0 {
+			continue
+		}
+		if attrs == nil {
+			attrs = map[string]string{}
+		}
+		attrs[name] = vals[0]
+	}
+	return attrs
 }
 
 func (s *Server) healthHandler(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintln(w, "ok")
 }
 
-// POST /upload  (body: raw text for now)
+// serverStats is the body of GET /stats.
+type serverStats struct {
+	EmbeddingCache *rag.CacheStats `json:"embedding_cache,omitempty"`
+}
+
+// GET /stats reports the embedding cache counters, when the embedder is
+// cached.
+func (s *Server) statsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	var stats serverStats
+	if c, ok := s.embedder.(*rag.CachedEmbedder); ok {
+		cache := c.Stats()
+		stats.EmbeddingCache = &cache
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// textUpload is the JSON form of a text upload
+// (Content-Type: application/json).
+type textUpload struct {
+	ID         string            `json:"id"`
+	Title      string            `json:"title"`
+	Text       string            `json:"text"`
+	Attributes map[string]string `json:"attributes"`
+	OnConflict string            `json:"on_conflict"`
+	Collection string            `json:"collection"`
+}
+
+// POST /upload?id=handbook&title=Handbook&on_conflict=replace&chunker=token&attr.team=search&collection=hr
+//
+// The body is the raw text, or a JSON textUpload. The document ID and title
+// can also be sent as X-Document-ID / X-Document-Title headers. Without an
+// ID a random one is generated, so uploads never collide by accident.
 func (s *Server) uploadHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
 	}
+	// Read options from the URL only: the body is the document itself.
+	q := r.URL.Query()
+	chunker, err := s.newChunker(q.Get("chunker"), q.Get("chunk_size"), q.Get("chunk_overlap"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
 		http.Error(w, "failed to read body", http.StatusBadRequest)
 		return
 	}
-	text := string(body)
-	if text == "" {
+
+	upload := textUpload{
+		ID:         firstNonEmpty(q.Get("id"), r.Header.Get("X-Document-ID")),
+		Title:      firstNonEmpty(q.Get("title"), r.Header.Get("X-Document-Title")),
+		Text:       string(body),
+		Attributes: attributesFrom(q),
+		OnConflict: firstNonEmpty(q.Get("on_conflict"), r.Header.Get("X-On-Conflict")),
+		Collection: q.Get("collection"),
+	}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "application/json" {
+		var req textUpload
+		if err := json.Unmarshal(body, &req); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		upload.ID = firstNonEmpty(req.ID, upload.ID)
+		upload.Title = firstNonEmpty(req.Title, upload.Title)
+		upload.OnConflict = firstNonEmpty(req.OnConflict, upload.OnConflict)
+		upload.Collection = firstNonEmpty(req.Collection, upload.Collection)
+		upload.Text = req.Text
+		for k, v := range req.Attributes {
+			if upload.Attributes == nil {
+				upload.Attributes = map[string]string{}
+			}
+			upload.Attributes[k] = v
+		}
+	}
+
+	if upload.Text == "" {
 		http.Error(w, "empty body", http.StatusBadRequest)
 		return
 	}
+	policy, err := s.conflictPolicy(upload.OnConflict)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if upload.ID == "" {
+		upload.ID = rag.NewDocumentID()
+	} else if err := rag.ValidateDocumentID(upload.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	coll, ok := s.collection(w, upload.Collection)
+	if !ok {
+		return
+	}
+	if rejectDuplicate(w, coll, upload.ID, policy) {
+		return
+	}
 
-	chunks := rag.ChunkText(text, "doc1", s.embedder)
+	// Split first: a document refused for its size costs no embeddings.
+	chunks, err := rag.SplitDocument(r.Context(), chunker, rag.Document{
+		ID:         upload.ID,
+		Source:     upload.Title,
+		Text:       upload.Text,
+		Attributes: upload.Attributes,
+	})
+	if err != nil {
+		embedFailed(w, err)
+		return
+	}
 
-	log.Printf("upload_text=%q chunks=%d\n", text, len(chunks))
+	log.Printf("upload_text=%q document_id=%q chunks=%d\n", upload.Text, upload.ID, len(chunks))
 
 	if len(chunks) > 5 {
-	    log.Printf("error - text too big")
+		log.Printf("error - text too big")
 		http.Error(w, "text too big", http.StatusBadRequest)
 		return
 	}
+	if err := rag.EmbedChunks(r.Context(), s.embedder, chunks); err != nil {
+		embedFailed(w, err)
+		return
+	}
 
-	s.store.Add(chunks...)
+	replaced, ok := storeDocument(w, coll, upload.ID, policy, chunks)
+	if !ok {
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]any{
-		"chunks_added": len(chunks),
+		"chunks_added":    len(chunks),
+		"chunks_replaced": replaced,
+		"collection":      coll.Name(),
+		"document_id":     upload.ID,
 	})
 }
 
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
 func (s *Server) uploadPDFHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		w.WriteHeader(http.StatusMethodNotAllowed)
@@ -94,6 +524,12 @@ func (s *Server) uploadPDFHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	chunker, err := s.newChunker(r.FormValue("chunker"), r.FormValue("chunk_size"), r.FormValue("chunk_overlap"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	file, header, err := r.FormFile("file")
 	if err != nil {
 		http.Error(w, "missing file field", http.StatusBadRequest)
@@ -101,6 +537,25 @@ func (s *Server) uploadPDFHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	defer file.Close()
 
+	// A PDF is identified by its file name unless the form says otherwise.
+	docID := firstNonEmpty(r.FormValue("id"), header.Filename)
+	if err := rag.ValidateDocumentID(docID); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	policy, err := s.conflictPolicy(r.FormValue("on_conflict"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	coll, ok := s.collection(w, r.FormValue("collection"))
+	if !ok {
+		return
+	}
+	if rejectDuplicate(w, coll, docID, policy) {
+		return
+	}
+
 	// Save to temp file because pdf library works with file paths
 	tmp, err := os.CreateTemp("", "upload-*.pdf")
 	if err != nil {
@@ -115,75 +570,392 @@ func (s *Server) uploadPDFHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-    f, rdr, err := openPDF(tmp.Name())
-    if err != nil {
-        http.Error(w, "failed to open pdf", http.StatusInternalServerError)
-        return
-    }
-    if f != nil {
-        defer f.Close()
-    }
+	f, rdr, err := openPDF(tmp.Name())
+	if err != nil {
+		http.Error(w, "failed to open pdf", http.StatusInternalServerError)
+		return
+	}
+	if f != nil {
+		defer f.Close()
+	}
 
-	var buf bytes.Buffer
-	b, err := rdr.GetPlainText()
+	text, pages, err := readPDFText(rdr)
 	if err != nil {
 		http.Error(w, "failed to read pdf text", http.StatusInternalServerError)
 		return
 	}
-	if _, err := io.Copy(&buf, b); err != nil {
-		http.Error(w, "failed to read pdf buffer", http.StatusInternalServerError)
+
+	if text == "" {
+		http.Error(w, "no text extracted from pdf", http.StatusBadRequest)
 		return
 	}
 
-    text := buf.String()
-
-    // Normalize PDF text a bit: replace newlines with spaces and trim
-    text = strings.ReplaceAll(text, "\r\n", " ")
-    text = strings.ReplaceAll(text, "\n", " ")
-    text = strings.Join(strings.Fields(text), " ") // collapse multiple spaces
-    text = strings.TrimSpace(text)
-
-    if text == "" {
-        http.Error(w, "no text extracted from pdf", http.StatusBadRequest)
-        return
-    }
-
 	source := header.Filename
-	chunks := rag.ChunkText(text, source, s.embedder)
-	s.store.Add(chunks...)
+	chunks, err := rag.SplitDocument(r.Context(), chunker, rag.Document{
+		ID:         docID,
+		Source:     source,
+		Text:       text,
+		Attributes: attributesFrom(r.Form),
+		Pages:      pages,
+	})
+	if err != nil {
+		embedFailed(w, err)
+		return
+	}
 
-	log.Printf("upload_pdf=%q chunks_added=%d\n", source, len(chunks))
+	log.Printf("upload_pdf=%q document_id=%q chunks_added=%d\n", source, docID, len(chunks))
 
 	if len(chunks) > 5 {
-	    log.Printf("error - pdf too big")
+		log.Printf("error - pdf too big")
 		http.Error(w, "pdf too big", http.StatusBadRequest)
 		return
 	}
+	if err := rag.EmbedChunks(r.Context(), s.embedder, chunks); err != nil {
+		embedFailed(w, err)
+		return
+	}
+
+	replaced, ok := storeDocument(w, coll, docID, policy, chunks)
+	if !ok {
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]any{
-		"chunks_added": len(chunks),
-		"filename":     source,
+		"chunks_added":    len(chunks),
+		"chunks_replaced": replaced,
+		"collection":      coll.Name(),
+		"document_id":     docID,
+		"filename":        source,
 	})
 }
 
+// POST /reset clears every collection; POST /reset?collection=hr only one.
 func (s *Server) resetHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
 	}
 
-	s.store.Clear()
+	var colls []*rag.Collection
+	if name := r.URL.Query().Get("collection"); name != "" {
+		coll, ok := s.collection(w, name)
+		if !ok {
+			return
+		}
+		colls = append(colls, coll)
+	} else {
+		for _, info := range s.collections.List() {
+			if coll, ok := s.collections.Get(info.Name); ok {
+				colls = append(colls, coll)
+			}
+		}
+	}
+	for _, coll := range colls {
+		if err := coll.Clear(); err != nil {
+			log.Printf("error - reset failed: %v", err)
+			writeFailed(w, "failed to reset store", err)
+			return
+		}
+	}
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// GET /documents lists the stored documents.
+// DELETE /documents?source=report.pdf removes every chunk uploaded from
+// that source, whatever document ID it was stored under.
+func (s *Server) documentsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodDelete {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	coll, ok := s.collection(w, r.URL.Query().Get("collection"))
+	if !ok {
+		return
+	}
+	if r.Method == http.MethodDelete {
+		deleteSource(w, coll, r.URL.Query().Get("source"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(coll.Documents())
+}
+
+func deleteSource(w http.ResponseWriter, coll *rag.Collection, source string) {
+	if source == "" {
+		http.Error(w, "missing source", http.StatusBadRequest)
+		return
+	}
+
+	deleted, err := coll.DeleteSource(source)
+	if err != nil {
+		log.Printf("error - delete failed: %v", err)
+		writeFailed(w, "failed to delete source", err)
+		return
+	}
+	log.Printf("delete_source=%q chunks_deleted=%d\n", source, deleted)
+	if deleted == 0 {
+		http.Error(w, "source not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"chunks_deleted": deleted,
+		"source":         source,
+	})
+}
+
+// documentResponse is one document with its chunks in order.
+type documentResponse struct {
+	rag.DocumentInfo
+	Chunks []chunkView
+}
 
+// chunkView is a chunk as the inspection endpoints show it: without its
+// embedding, which is long and rarely wanted, unless asked for.
+type chunkView struct {
+	rag.Chunk
+	Embedding []float64 `json:",omitempty"` // shadows Chunk.Embedding
+}
+
+// viewChunk shows ch, with its embedding if ?embeddings=true.
+func viewChunk(r *http.Request, ch rag.Chunk) chunkView {
+	v := chunkView{Chunk: ch}
+	if r.URL.Query().Get("embeddings") == "true" {
+		v.Embedding = ch.Embedding
+	}
+	return v
+}
+
+// GET /documents/{id}?embeddings=true returns a document and its chunks in
+// order, embeddings only on request.
+// DELETE /documents/{id} removes every chunk of the document.
+// Both take ?collection=, like every /documents and /chunks endpoint.
+func (s *Server) documentHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodDelete {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	coll, ok := s.collection(w, r.URL.Query().Get("collection"))
+	if !ok {
+		return
+	}
+	if r.Method == http.MethodDelete {
+		deleteDocument(w, coll, r.PathValue("id"))
+		return
+	}
+	getDocument(w, r, coll, r.PathValue("id"))
+}
+
+func getDocument(w http.ResponseWriter, r *http.Request, coll *rag.Collection, docID string) {
+	chunks := coll.DocumentChunks(docID)
+	if len(chunks) == 0 {
+		http.Error(w, "document not found", http.StatusNotFound)
+		return
+	}
+	resp := documentResponse{Chunks: make([]chunkView, len(chunks))}
+	for i, ch := range chunks {
+		resp.Chunks[i] = viewChunk(r, ch)
+	}
+	for _, d := range coll.Documents() {
+		if d.ID == docID {
+			resp.DocumentInfo = d
+			break
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func deleteDocument(w http.ResponseWriter, coll *rag.Collection, docID string) {
+	deleted, err := coll.DeleteDocument(docID)
+	if err != nil {
+		log.Printf("error - delete failed: %v", err)
+		writeFailed(w, "failed to delete document", err)
+		return
+	}
+	log.Printf("delete_document=%q chunks_deleted=%d\n", docID, deleted)
+	if deleted == 0 {
+		http.Error(w, "document not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"chunks_deleted": deleted,
+		"document_id":    docID,
+	})
+}
+
+// GET /chunks/{id}?embeddings=true returns one chunk, embedding only on
+// request.
+func (s *Server) chunkHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	coll, ok := s.collection(w, r.URL.Query().Get("collection"))
+	if !ok {
+		return
+	}
+	ch, ok := coll.Chunk(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "chunk not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(viewChunk(r, ch))
+}
+
+type createCollectionRequest struct {
+	Name  string `json:"name"`
+	Model string `json:"model,omitempty"`
+}
+
+// GET /collections lists the collections.
+// POST /collections  { "name": "hr", "model": "..." } creates one; the
+// model defaults to the server's embedder.
+func (s *Server) collectionsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.collections.List())
+	case http.MethodPost:
+		var req createCollectionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if err := rag.ValidateCollectionName(req.Name); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		coll, err := s.collections.Create(req.Name, req.Model)
+		if errors.Is(err, rag.ErrCollectionExists) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			log.Printf("error - create collection failed: %v", err)
+			http.Error(w, "failed to create collection", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("create_collection=%q model=%q\n", req.Name, req.Model)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(coll.Info())
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// GET /collections/{name} summarizes one collection.
+// DELETE /collections/{name} drops it with all its documents.
+func (s *Server) collectionHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	switch r.Method {
+	case http.MethodGet:
+		coll, ok := s.collection(w, name)
+		if !ok {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(coll.Info())
+	case http.MethodDelete:
+		if name == rag.DefaultCollection {
+			http.Error(w, "the default collection cannot be deleted", http.StatusBadRequest)
+			return
+		}
+		err := s.collections.Delete(name)
+		if errors.Is(err, rag.ErrCollectionNotFound) {
+			http.Error(w, "collection not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("error - delete collection failed: %v", err)
+			http.Error(w, "failed to delete collection", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("delete_collection=%q\n", name)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// Query modes: how /query ranks chunks.
+const (
+	queryModeVector  = "vector"  // cosine similarity of embeddings (default)
+	queryModeKeyword = "keyword" // BM25 over the words of the query
+	queryModeHybrid  = "hybrid"  // both, fused (see rag.Fuse)
+)
+
+const (
+	defaultTopK = 3
+	// defaultMaxTopK caps top_k unless MAX_TOP_K says otherwise.
+	defaultMaxTopK = 50
+	// maxResultWindow bounds offset plus top_k: every page ranks that
+	// many candidates per collection and retriever, plus one to tell
+	// whether there is a next page.
+	maxResultWindow = 1000
+	// mmrWindow is how many candidates MMR picks from at least, so pages
+	// within it line up from one request to the next.
+	mmrWindow = 100
+)
 
 type queryRequest struct {
-	Query string `json:"query"`
+	Query  string      `json:"query"`
+	Mode   string      `json:"mode,omitempty"`
+	Filter *rag.Filter `json:"filter,omitempty"`
+	// Hybrid mode only.
+	Fusion rag.FusionMethod `json:"fusion,omitempty"`
+	Alpha  *float64         `json:"alpha,omitempty"`
+	// MMR re-ranks for diversity; Lambda (default 0.5) trades relevance
+	// (1) against diversity (0).
+	MMR    bool     `json:"mmr,omitempty"`
+	Lambda *float64 `json:"lambda,omitempty"`
+	// Collections to search; the default collection when empty.
+	Collection  string   `json:"collection,omitempty"`
+	Collections []string `json:"collections,omitempty"`
+
+	// TopK results per page (default 3, at most the server's max).
+	TopK *int `json:"top_k,omitempty"`
+	// MinScore drops weaker results. Defaults to the server's threshold
+	// in vector mode and to 0 otherwise, as scores are not cosines there.
+	MinScore *float64 `json:"min_score,omitempty"`
+	// Offset skips results; Cursor, taken from the X-Next-Cursor header of
+	// the previous page, does the same. Send at most one.
+	Offset int    `json:"offset,omitempty"`
+	Cursor string `json:"cursor,omitempty"`
+}
+
+// encodeCursor and decodeCursor turn a result offset into an opaque
+// pagination token and back.
+func encodeCursor(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte("offset:" + strconv.Itoa(offset)))
+}
+
+func decodeCursor(cursor string) (int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err == nil {
+		if n, ok := strings.CutPrefix(string(data), "offset:"); ok {
+			if offset, err := strconv.Atoi(n); err == nil && offset >= 0 {
+				return offset, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("invalid cursor %q", cursor)
 }
 
-// POST /query  { "query": "your question" }
+// POST /query  { "query": "your question", "mode": "hybrid", "mmr": true, "filter": {...}, "collections": ["hr"],
+// "top_k": 10, "min_score": 0.5, "cursor": "..." }
+//
+// The response is one page of results. X-Total-Count holds how many
+// results passed min_score, when that can be counted, and X-Next-Cursor,
+// when there are more, the cursor for the next page.
 func (s *Server) queryHandler(w http.ResponseWriter, r *http.Request) {
 
 	if r.Method != http.MethodPost {
@@ -199,46 +971,237 @@ func (s *Server) queryHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "query is required", http.StatusBadRequest)
 		return
 	}
+	if req.Mode == "" {
+		req.Mode = queryModeVector
+	}
+	if req.Mode != queryModeVector && req.Mode != queryModeKeyword && req.Mode != queryModeHybrid {
+		http.Error(w, fmt.Sprintf("unknown mode %q (want %s, %s or %s)", req.Mode, queryModeVector, queryModeKeyword, queryModeHybrid), http.StatusBadRequest)
+		return
+	}
+	fusion := rag.FusionOptions{Method: req.Fusion, Alpha: req.Alpha}
+	if err := fusion.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	lambda := 0.5
+	if req.Lambda != nil {
+		lambda = *req.Lambda
+	}
+	if lambda < 0 || lambda > 1 {
+		http.Error(w, fmt.Sprintf("lambda %v is outside [0, 1]", lambda), http.StatusBadRequest)
+		return
+	}
+	topK := defaultTopK
+	if req.TopK != nil {
+		topK = *req.TopK
+	}
+	if topK < 1 || topK > s.maxTopK {
+		http.Error(w, fmt.Sprintf("top_k must be between 1 and %d", s.maxTopK), http.StatusBadRequest)
+		return
+	}
+	offset := req.Offset
+	if req.Cursor != "" {
+		if offset != 0 {
+			http.Error(w, "send either offset or cursor, not both", http.StatusBadRequest)
+			return
+		}
+		var err error
+		if offset, err = decodeCursor(req.Cursor); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if offset < 0 || offset+topK > maxResultWindow {
+		http.Error(w, fmt.Sprintf("offset plus top_k must be between 1 and %d", maxResultWindow), http.StatusBadRequest)
+		return
+	}
+	if req.Filter != nil {
+		if err := req.Filter.Validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
 
-	qEmbedding := s.embedder.Embed(req.Query)
-	results := s.store.Search(qEmbedding, 3)
+	names := req.Collections
+	if req.Collection != "" {
+		names = append(names, req.Collection)
+	}
+	if len(names) == 0 {
+		names = []string{rag.DefaultCollection}
+	}
+	var colls []*rag.Collection
+	for _, name := range names {
+		coll, ok := s.collection(w, name)
+		if !ok {
+			return
+		}
+		colls = append(colls, coll)
+	}
 
-    log.Printf("query=%q\n", req.Query)
-	for _, r := range results {
-        log.Printf("query=%q chunk=%q score=%.3f\n", req.Query, r.Chunk.Content, r.Score)
-    }
+	// Ranking stops one result past the page, which is enough to tell
+	// whether another page follows.
+	k := offset + topK + 1
+	if req.MMR {
+		k = max(k, mmrWindow)
+	}
+	opts := rag.SearchOptions{Filter: req.Filter}
+	var search func(coll *rag.Collection) []rag.SearchResult
+	var qEmbedding []float64
+	minScore := s.minScore
+	switch req.Mode {
+	case queryModeKeyword:
+		search = func(coll *rag.Collection) []rag.SearchResult {
+			return coll.KeywordSearch(req.Query, k, opts)
+		}
+		// BM25 scores are not on the cosine scale; any match counts.
+		minScore = 0
+	default:
+		var err error
+		if qEmbedding, err = s.embedder.Embed(r.Context(), req.Query); err != nil {
+			embedFailed(w, err)
+			return
+		}
+		model := rag.EmbedderModel(s.embedder)
+		for _, coll := range colls {
+			if err := coll.CheckQuery(qEmbedding, model); err != nil {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+		}
+		search = func(coll *rag.Collection) []rag.SearchResult {
+			return coll.SearchWith(qEmbedding, k, opts)
+		}
+		if req.Mode == queryModeHybrid {
+			search = func(coll *rag.Collection) []rag.SearchResult {
+				return rag.Fuse(
+					atLeast(coll.SearchWith(qEmbedding, k, opts), s.minScore),
+					coll.KeywordSearch(req.Query, k, opts),
+					k, fusion)
+			}
+			// Weak vector hits are dropped before fusion; fused scores are
+			// not on the cosine scale.
+			minScore = 0
+		}
+	}
+	if req.MinScore != nil {
+		minScore = *req.MinScore
+	}
+	filtered := atLeast(searchCollections(colls, k, search), minScore)
+	if req.MMR {
+		// Greedy MMR picks the same first results however many are asked
+		// for, so pages within the window line up.
+		filtered = rag.MMR(filtered, offset+topK+1, lambda)
+	}
+	page := filtered[min(offset, len(filtered)):min(offset+topK, len(filtered))]
 
-    filtered := make([]rag.SearchResult, 0, len(results))
-    for _, r := range results {
-        if r.Score >= s.minScore {
-            filtered = append(filtered, r)
-        }
-    }
+	// The total comes from a counting pass rather than from ranking every
+	// match. Fused scores depend on ranks, so a hybrid total is only known
+	// when min_score keeps everything either retriever finds.
+	count := rag.CountQuery{Filter: req.Filter}
+	countable := true
+	switch req.Mode {
+	case queryModeKeyword:
+		count.Text, count.MinKeywordScore = req.Query, minScore
+	case queryModeVector:
+		count.Embedding, count.MinScore = qEmbedding, minScore
+	case queryModeHybrid:
+		count.Embedding, count.MinScore, count.Text = qEmbedding, s.minScore, req.Query
+		countable = minScore <= 0
+	}
+	var total int
+	var counted bool
+	if countable {
+		total, counted = countMatches(colls, count)
+	}
 
-    if len(filtered) == 0 {
-        w.Header().Set("Content-Type", "application/json")
-        w.WriteHeader(http.StatusOK)
-        json.NewEncoder(w).Encode([]rag.SearchResult{}) // empty list
-        return
-    }
+	log.Printf("query=%q mode=%s mmr=%t total=%d counted=%t offset=%d\n", req.Query, req.Mode, req.MMR, total, counted, offset)
+	for _, r := range page {
+		log.Printf("query=%q chunk=%q score=%.3f\n", req.Query, r.Chunk.Content, r.Score)
+	}
 
-    w.Header().Set("Content-Type", "application/json")
-    json.NewEncoder(w).Encode(filtered)
+	w.Header().Set("Content-Type", "application/json")
+	if counted {
+		w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	}
+	if next := offset + topK; len(filtered) > next && next < maxResultWindow {
+		w.Header().Set("X-Next-Cursor", encodeCursor(next))
+	}
+	json.NewEncoder(w).Encode(page)
 }
 
-func main() {
-	srv := NewServer()
+// atLeast returns the results scoring minScore or more.
+func atLeast(results []rag.SearchResult, minScore float64) []rag.SearchResult {
+	kept := make([]rag.SearchResult, 0, len(results))
+	for _, r := range results {
+		if r.Score >= minScore {
+			kept = append(kept, r)
+		}
+	}
+	return kept
+}
 
-	http.HandleFunc("/health", srv.healthHandler)
-	http.HandleFunc("/upload", srv.uploadHandler)
-	http.HandleFunc("/query", srv.queryHandler)
-	http.HandleFunc("/upload-pdf", srv.uploadPDFHandler)
-    http.HandleFunc("/reset", srv.resetHandler)
+// countMatches adds up the matches for q in each collection. It reports
+// false when a collection cannot count them (see rag.Counter).
+func countMatches(colls []*rag.Collection, q rag.CountQuery) (int, bool) {
+	total := 0
+	seen := map[string]bool{}
+	for _, coll := range colls {
+		if seen[coll.Name()] {
+			continue
+		}
+		seen[coll.Name()] = true
+		n, ok := coll.CountMatches(q)
+		if !ok {
+			return 0, false
+		}
+		total += n
+	}
+	return total, true
+}
+
+// searchCollections runs search on each collection and merges the results
+// by score, keeping the best topK overall.
+func searchCollections(colls []*rag.Collection, topK int, search func(*rag.Collection) []rag.SearchResult) []rag.SearchResult {
+	var results []rag.SearchResult
+	seen := map[string]bool{}
+	for _, coll := range colls {
+		if seen[coll.Name()] {
+			continue
+		}
+		seen[coll.Name()] = true
+		for _, r := range search(coll) {
+			r.Collection = coll.Name()
+			results = append(results, r)
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Score > results[j].Score })
+	return results[:min(topK, len(results))]
+}
 
+// routes maps every endpoint to its handler. Document and chunk IDs may
+// contain slashes ("team/2025-01"), so they take the rest of the path.
+func (s *Server) routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", s.healthHandler)
+	mux.HandleFunc("/stats", s.statsHandler)
+	mux.HandleFunc("/upload", s.uploadHandler)
+	mux.HandleFunc("/query", s.queryHandler)
+	mux.HandleFunc("/upload-pdf", s.uploadPDFHandler)
+	mux.HandleFunc("/reset", s.resetHandler)
+	mux.HandleFunc("/documents", s.documentsHandler)
+	mux.HandleFunc("/documents/{id...}", s.documentHandler)
+	mux.HandleFunc("/chunks/{id...}", s.chunkHandler)
+	mux.HandleFunc("/collections", s.collectionsHandler)
+	mux.HandleFunc("/collections/{name}", s.collectionHandler)
 
 	fs := http.FileServer(http.Dir("./frontend"))
-    http.Handle("/", fs)
+	mux.Handle("/", fs)
+	return mux
+}
+
+func main() {
+	srv := NewServer()
 
 	fmt.Println("Server running on http://localhost:8080")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	log.Fatal(http.ListenAndServe(":8080", srv.routes()))
 }
@@ -2,6 +2,10 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"mime/multipart"
@@ -16,9 +20,31 @@ import (
 
 type FakeEmbedder struct{}
 
-func (f *FakeEmbedder) Embed(text string) []float64 {
+func (f *FakeEmbedder) Embed(ctx context.Context, text string) ([]float64, error) {
 	// deterministic embedding
-	return []float64{0.1, 0.2, 0.3}
+	return []float64{0.1, 0.2, 0.3}, nil
+}
+
+// errEmbedder fails every call with err.
+type errEmbedder struct {
+	err error
+}
+
+func (f *errEmbedder) Embed(ctx context.Context, text string) ([]float64, error) {
+	return nil, f.err
+}
+
+// scriptedEmbedder returns a fixed vector per text, and a zero vector for
+// any other text.
+type scriptedEmbedder struct {
+	vectors map[string][]float64
+}
+
+func (f *scriptedEmbedder) Embed(ctx context.Context, text string) ([]float64, error) {
+	if v, ok := f.vectors[text]; ok {
+		return v, nil
+	}
+	return []float64{0, 0, 0}, nil
 }
 
 type fakePDFReader struct {
@@ -29,6 +55,21 @@ func (f *fakePDFReader) GetPlainText() (io.Reader, error) {
 	return strings.NewReader(f.text), nil
 }
 
+// fakePagedPDFReader also exposes its text page by page.
+type fakePagedPDFReader struct {
+	pages []string
+}
+
+func (f *fakePagedPDFReader) GetPlainText() (io.Reader, error) {
+	return strings.NewReader(strings.Join(f.pages, "")), nil
+}
+
+func (f *fakePagedPDFReader) NumPage() int { return len(f.pages) }
+
+func (f *fakePagedPDFReader) PageText(num int) (string, error) {
+	return f.pages[num-1], nil
+}
+
 func captureLogs(t *testing.T, fn func()) string {
 	t.Helper()
 	var buf bytes.Buffer
@@ -72,6 +113,7 @@ func TestUploadHandler(t *testing.T) {
 	tests := []struct {
 		name           string
 		method         string
+		target         string // defaults to /upload
 		body           string
 		wantStatusCode int
 		wantLogSubstr  string // if empty, we assert there are no logs
@@ -97,6 +139,30 @@ func TestUploadHandler(t *testing.T) {
 			wantStatusCode: http.StatusBadRequest,
 			wantLogSubstr:  "",
 		},
+		{
+			name:           "custom_chunker",
+			method:         http.MethodPost,
+			target:         "/upload?chunker=fixed&chunk_size=50",
+			body:           "This is a valid small text",
+			wantStatusCode: http.StatusOK,
+			wantLogSubstr:  "upload_text=",
+		},
+		{
+			name:           "unknown_chunker",
+			method:         http.MethodPost,
+			target:         "/upload?chunker=bogus",
+			body:           "This is a valid small text",
+			wantStatusCode: http.StatusBadRequest,
+			wantLogSubstr:  "",
+		},
+		{
+			name:           "invalid_chunk_size",
+			method:         http.MethodPost,
+			target:         "/upload?chunk_size=abc",
+			body:           "This is a valid small text",
+			wantStatusCode: http.StatusBadRequest,
+			wantLogSubstr:  "",
+		},
 		{
 			name:           "too_many_chunks",
 			method:         http.MethodPost,
@@ -114,7 +180,11 @@ func TestUploadHandler(t *testing.T) {
 				bodyReader = strings.NewReader(tc.body)
 			}
 
-			req := httptest.NewRequest(tc.method, "/upload", bodyReader)
+			target := tc.target
+			if target == "" {
+				target = "/upload"
+			}
+			req := httptest.NewRequest(tc.method, target, bodyReader)
 			w := httptest.NewRecorder()
 
 			logs := captureLogs(t, func() {
@@ -138,6 +208,123 @@ func TestUploadHandler(t *testing.T) {
 	}
 }
 
+func TestUploadHandler_TooBigSkipsEmbedding(t *testing.T) {
+	// Any call to the embedder would fail the upload with 503 instead.
+	srv := NewServerWithEmbedder(&errEmbedder{err: rag.ErrEmbedderUnavailable})
+	req := httptest.NewRequest(http.MethodPost, "/upload", strings.NewReader(strings.Repeat("This is a sentence that will be chunked. ", 500)))
+	w := httptest.NewRecorder()
+	captureLogs(t, func() {
+		srv.uploadHandler(w, req)
+	})
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 before any embedding, got %d", w.Code)
+	}
+}
+
+func TestUploadHandler_DocumentIDs(t *testing.T) {
+	upload := func(srv *Server, target, contentType, body string, header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		captureLogs(t, func() {
+			srv.uploadHandler(w, req)
+		})
+		return w
+	}
+	decode := func(t *testing.T, w *httptest.ResponseRecorder) map[string]any {
+		t.Helper()
+		var out map[string]any
+		if err := json.NewDecoder(w.Body).Decode(&out); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		return out
+	}
+
+	t.Run("generated_ids_do_not_collide", func(t *testing.T) {
+		srv := newTestServer()
+		first := decode(t, upload(srv, "/upload", "", "Same text.", nil))
+		second := decode(t, upload(srv, "/upload", "", "Same text.", nil))
+
+		if first["document_id"] == second["document_id"] {
+			t.Fatalf("expected distinct generated ids, got %v twice", first["document_id"])
+		}
+		seen := map[string]bool{}
+		for _, r := range srv.store.Search([]float64{0.1, 0.2, 0.3}, 10) {
+			if seen[r.Chunk.ID] {
+				t.Fatalf("duplicate chunk id %q", r.Chunk.ID)
+			}
+			seen[r.Chunk.ID] = true
+		}
+	})
+
+	t.Run("query_param_and_conflicts", func(t *testing.T) {
+		srv := newTestServer()
+		if w := upload(srv, "/upload?id=handbook&title=Handbook", "", "Version one.", nil); w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", w.Code)
+		}
+		if w := upload(srv, "/upload?id=handbook", "", "Version two.", nil); w.Code != http.StatusConflict {
+			t.Fatalf("expected 409 on duplicate id, got %d", w.Code)
+		}
+		w := upload(srv, "/upload?id=handbook&on_conflict=replace", "", "Version two.", nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200 on replace, got %d", w.Code)
+		}
+		if out := decode(t, w); out["chunks_replaced"] != float64(1) {
+			t.Fatalf("expected 1 replaced chunk, got %v", out["chunks_replaced"])
+		}
+
+		results := srv.store.Search([]float64{0.1, 0.2, 0.3}, 10)
+		if len(results) != 1 || results[0].Chunk.Content != "Version two." || results[0].Chunk.ID != "handbook-1" {
+			t.Fatalf("expected only the replacement chunk, got %+v", results)
+		}
+	})
+
+	t.Run("headers", func(t *testing.T) {
+		srv := newTestServer()
+		w := upload(srv, "/upload", "", "Some text.", map[string]string{
+			"X-Document-ID":    "from-header",
+			"X-Document-Title": "Header Title",
+		})
+		if out := decode(t, w); out["document_id"] != "from-header" {
+			t.Fatalf("expected id from header, got %v", out["document_id"])
+		}
+		if res := srv.store.Search([]float64{0.1, 0.2, 0.3}, 1); res[0].Chunk.Source != "Header Title" {
+			t.Fatalf("expected title as source, got %q", res[0].Chunk.Source)
+		}
+	})
+
+	t.Run("json_body", func(t *testing.T) {
+		srv := newTestServer()
+		body := `{"id":"contract-7","title":"Contract","text":"Clause one.","attributes":{"customer":"acme"}}`
+		w := upload(srv, "/upload", "application/json", body, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		ch := srv.store.Search([]float64{0.1, 0.2, 0.3}, 1)[0].Chunk
+		if ch.DocumentID != "contract-7" || ch.Content != "Clause one." || ch.Attributes["customer"] != "acme" {
+			t.Fatalf("unexpected chunk %+v", ch)
+		}
+	})
+
+	t.Run("invalid_requests", func(t *testing.T) {
+		srv := newTestServer()
+		if w := upload(srv, "/upload", "application/json", `{"text":`, nil); w.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400 for invalid json, got %d", w.Code)
+		}
+		if w := upload(srv, "/upload?on_conflict=merge", "", "text", nil); w.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400 for unknown policy, got %d", w.Code)
+		}
+		if w := upload(srv, "/upload?id=%20padded", "", "text", nil); w.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400 for invalid id, got %d", w.Code)
+		}
+	})
+}
+
 func TestUploadPDFHandler(t *testing.T) {
 	srv := newTestServer()
 
@@ -176,6 +363,88 @@ func TestUploadPDFHandler(t *testing.T) {
 		}
 	})
 
+	t.Run("pages_and_attributes", func(t *testing.T) {
+		srv := newTestServer()
+		openPDF = func(path string) (*os.File, PDFReader, error) {
+			return nil, &fakePagedPDFReader{pages: []string{"First page.\nStill first.", "", "Third page."}}, nil
+		}
+
+		var buf bytes.Buffer
+		writer := multipart.NewWriter(&buf)
+		writer.WriteField("chunker", "sentence")
+		writer.WriteField("chunk_size", "1")
+		writer.WriteField("attr.customer", "acme")
+		part, err := writer.CreateFormFile("file", "paged.pdf")
+		if err != nil {
+			t.Fatalf("failed to create form file: %v", err)
+		}
+		part.Write([]byte("dummy pdf bytes"))
+		writer.Close()
+
+		req := httptest.NewRequest(http.MethodPost, "/upload-pdf", &buf)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		w := httptest.NewRecorder()
+
+		captureLogs(t, func() {
+			srv.uploadPDFHandler(w, req)
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		results := srv.store.Search([]float64{0.1, 0.2, 0.3}, 10)
+		pages := map[string]int{}
+		for _, r := range results {
+			pages[r.Chunk.Content] = r.Chunk.Page
+			if r.Chunk.Attributes["customer"] != "acme" {
+				t.Fatalf("expected customer attribute on %q, got %v", r.Chunk.Content, r.Chunk.Attributes)
+			}
+		}
+		if pages["First page."] != 1 || pages["Still first."] != 1 || pages["Third page."] != 3 {
+			t.Fatalf("unexpected page numbers %v", pages)
+		}
+	})
+
+	t.Run("duplicate_filename", func(t *testing.T) {
+		srv := newTestServer()
+		openPDF = func(path string) (*os.File, PDFReader, error) {
+			return nil, &fakePDFReader{text: "Text extracted from PDF"}, nil
+		}
+
+		send := func(extra map[string]string) int {
+			var buf bytes.Buffer
+			writer := multipart.NewWriter(&buf)
+			for k, v := range extra {
+				writer.WriteField(k, v)
+			}
+			part, _ := writer.CreateFormFile("file", "same.pdf")
+			part.Write([]byte("dummy pdf bytes"))
+			writer.Close()
+
+			req := httptest.NewRequest(http.MethodPost, "/upload-pdf", &buf)
+			req.Header.Set("Content-Type", writer.FormDataContentType())
+			w := httptest.NewRecorder()
+			captureLogs(t, func() {
+				srv.uploadPDFHandler(w, req)
+			})
+			return w.Code
+		}
+
+		if code := send(nil); code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", code)
+		}
+		if code := send(nil); code != http.StatusConflict {
+			t.Fatalf("expected 409 for the same file name, got %d", code)
+		}
+		if code := send(map[string]string{"on_conflict": "replace"}); code != http.StatusOK {
+			t.Fatalf("expected 200 with on_conflict=replace, got %d", code)
+		}
+		if code := send(map[string]string{"id": "same-v2"}); code != http.StatusOK {
+			t.Fatalf("expected 200 with an explicit id, got %d", code)
+		}
+	})
+
 	t.Run("method_not_allowed", func(t *testing.T) {
 		// openPDF not used in this path
 		req := httptest.NewRequest(http.MethodGet, "/upload-pdf", nil)
@@ -297,7 +566,7 @@ func TestQueryHandler(t *testing.T) {
 		// Seed store with one chunk so query returns something
 		srv.store.Add(rag.Chunk{
 			Content:   "hello world",
-			Embedding: srv.embedder.Embed("hello world"),
+			Embedding: []float64{0.1, 0.2, 0.3},
 			Source:    "doc1",
 		})
 
@@ -319,6 +588,264 @@ func TestQueryHandler(t *testing.T) {
 		}
 	})
 
+	t.Run("returns_chunk_metadata", func(t *testing.T) {
+		srv := newTestServer()
+		upload := httptest.NewRequest(http.MethodPost, "/upload?attr.team=search", strings.NewReader("Hello world."))
+		captureLogs(t, func() {
+			srv.uploadHandler(httptest.NewRecorder(), upload)
+		})
+
+		req := httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(`{"query":"hello"}`))
+		w := httptest.NewRecorder()
+		captureLogs(t, func() {
+			srv.queryHandler(w, req)
+		})
+
+		var results []rag.SearchResult
+		if err := json.NewDecoder(w.Body).Decode(&results); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if len(results) != 1 {
+			t.Fatalf("expected 1 result, got %d", len(results))
+		}
+		ch := results[0].Chunk
+		if ch.DocumentID == "" || ch.End != len("Hello world.") || ch.CreatedAt.IsZero() || ch.Attributes["team"] != "search" {
+			t.Fatalf("expected chunk metadata in response, got %+v", ch)
+		}
+	})
+
+	t.Run("filter", func(t *testing.T) {
+		srv := newTestServer()
+		for _, target := range []string{"/upload?id=a&attr.customer=acme", "/upload?id=b&attr.customer=globex"} {
+			upload := httptest.NewRequest(http.MethodPost, target, strings.NewReader("Hello world."))
+			captureLogs(t, func() {
+				srv.uploadHandler(httptest.NewRecorder(), upload)
+			})
+		}
+
+		body := `{"query":"hello","filter":{"or":[{"field":"attr.customer","eq":"globex"},{"field":"source","in":["x.pdf"]}]}}`
+		req := httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		captureLogs(t, func() {
+			srv.queryHandler(w, req)
+		})
+
+		var results []rag.SearchResult
+		if err := json.NewDecoder(w.Body).Decode(&results); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if len(results) != 1 || results[0].Chunk.DocumentID != "b" {
+			t.Fatalf("expected only document b, got %+v", results)
+		}
+	})
+
+	t.Run("invalid_filter", func(t *testing.T) {
+		body := `{"query":"hello","filter":{"field":"colour","eq":"red"}}`
+		req := httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		srv.queryHandler(w, req)
+
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `unknown field "colour"`) {
+			t.Fatalf("expected 400 naming the bad field, got %d %q", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("keyword_mode", func(t *testing.T) {
+		srv := newTestServer()
+		srv.store.Add(
+			rag.Chunk{ID: "a", Content: "Checkout fails with ERR-4021.", Embedding: []float64{0.1, 0.2, 0.3}},
+			rag.Chunk{ID: "b", Content: "Checkout is slow.", Embedding: []float64{0.1, 0.2, 0.3}},
+		)
+
+		req := httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(`{"query":"err-4021","mode":"keyword"}`))
+		w := httptest.NewRecorder()
+		logs := captureLogs(t, func() {
+			srv.queryHandler(w, req)
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", w.Code)
+		}
+		var results []rag.SearchResult
+		json.NewDecoder(w.Body).Decode(&results)
+		if len(results) != 1 || results[0].Chunk.ID != "a" {
+			t.Fatalf("expected only chunk a, got %+v", results)
+		}
+		if !strings.Contains(logs, `query="err-4021" mode=keyword mmr=false total=1`) {
+			t.Fatalf("expected mode in logs, got %q", logs)
+		}
+	})
+
+	t.Run("hybrid_mode", func(t *testing.T) {
+		srv := newTestServer()
+		srv.store.Add(
+			rag.Chunk{ID: "a", Content: "Checkout is slow.", Embedding: []float64{0.1, 0.2, 0.3}},
+			rag.Chunk{ID: "b", Content: "Checkout fails with ERR-4021.", Embedding: []float64{0.1, 0.2, 0.3}},
+			rag.Chunk{ID: "c", Content: "Unrelated.", Embedding: []float64{-0.1, -0.2, -0.3}},
+		)
+
+		for _, body := range []string{
+			`{"query":"err-4021","mode":"hybrid"}`,
+			`{"query":"err-4021","mode":"hybrid","fusion":"weighted","alpha":0.3}`,
+		} {
+			req := httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(body))
+			w := httptest.NewRecorder()
+			captureLogs(t, func() {
+				srv.queryHandler(w, req)
+			})
+			if w.Code != http.StatusOK {
+				t.Fatalf("%s: expected 200, got %d", body, w.Code)
+			}
+			var results []rag.SearchResult
+			json.NewDecoder(w.Body).Decode(&results)
+			// b is found by both retrievers; c is too far from the query
+			// vector to be a candidate at all.
+			if len(results) != 2 || results[0].Chunk.ID != "b" || results[1].Chunk.ID != "a" {
+				t.Fatalf("%s: expected [b a], got %+v", body, results)
+			}
+			if results[0].Ranks["vector"] != 2 || results[0].Ranks["keyword"] != 1 || results[1].Ranks["keyword"] != 0 {
+				t.Fatalf("%s: unexpected ranks %v %v", body, results[0].Ranks, results[1].Ranks)
+			}
+		}
+
+		req := httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(`{"query":"x","mode":"hybrid","alpha":2}`))
+		w := httptest.NewRecorder()
+		srv.queryHandler(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400 for alpha 2, got %d", w.Code)
+		}
+	})
+
+	t.Run("mmr", func(t *testing.T) {
+		srv := newTestServer()
+		srv.embedder = &scriptedEmbedder{vectors: map[string][]float64{"refund policy": {1, 0, 0}}}
+		srv.store.Add(
+			rag.Chunk{ID: "a", Content: "Refunds take five days.", Embedding: []float64{1, 0.1, 0}},
+			rag.Chunk{ID: "a-dup", Content: "Refunds take 5 days.", Embedding: []float64{1, 0.1, 0.01}},
+			rag.Chunk{ID: "b", Content: "Refunds need a receipt.", Embedding: []float64{1, 0, 0.6}},
+			rag.Chunk{ID: "c", Content: "Refunds go to the card.", Embedding: []float64{1, -0.7, 0}},
+		)
+		query := func(body string) []string {
+			t.Helper()
+			req := httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(body))
+			w := httptest.NewRecorder()
+			captureLogs(t, func() {
+				srv.queryHandler(w, req)
+			})
+			if w.Code != http.StatusOK {
+				t.Fatalf("%s: expected 200, got %d", body, w.Code)
+			}
+			var results []rag.SearchResult
+			json.NewDecoder(w.Body).Decode(&results)
+			var ids []string
+			for _, r := range results {
+				ids = append(ids, r.Chunk.ID)
+			}
+			return ids
+		}
+
+		if got := fmt.Sprint(query(`{"query":"refund policy"}`)); got != "[a a-dup b]" {
+			t.Fatalf("without MMR: expected [a a-dup b], got %s", got)
+		}
+		if got := fmt.Sprint(query(`{"query":"refund policy","mmr":true,"lambda":0.5}`)); got != "[a c b]" {
+			t.Fatalf("with MMR: expected [a c b], got %s", got)
+		}
+
+		req := httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(`{"query":"x","mmr":true,"lambda":-1}`))
+		w := httptest.NewRecorder()
+		srv.queryHandler(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400 for lambda -1, got %d", w.Code)
+		}
+	})
+
+	t.Run("pagination", func(t *testing.T) {
+		srv := newTestServer()
+		srv.embedder = &scriptedEmbedder{vectors: map[string][]float64{"q": {1, 0, 0}}}
+		for i := range 7 {
+			// Scores fall from 1 as i grows.
+			srv.store.Add(rag.Chunk{ID: fmt.Sprint(i), Content: fmt.Sprint("chunk ", i), Embedding: []float64{1, float64(i) / 4, 0}})
+		}
+		query := func(body string) (*httptest.ResponseRecorder, []string) {
+			t.Helper()
+			req := httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(body))
+			w := httptest.NewRecorder()
+			captureLogs(t, func() {
+				srv.queryHandler(w, req)
+			})
+			var results []rag.SearchResult
+			json.NewDecoder(bytes.NewReader(w.Body.Bytes())).Decode(&results)
+			var ids []string
+			for _, r := range results {
+				ids = append(ids, r.Chunk.ID)
+			}
+			return w, ids
+		}
+
+		// Walk all pages through the cursor.
+		var pages []string
+		body := `{"query":"q","top_k":3}`
+		for {
+			w, ids := query(body)
+			if w.Code != http.StatusOK || w.Header().Get("X-Total-Count") != "7" {
+				t.Fatalf("%s: expected 200 and 7 in total, got %d %q", body, w.Code, w.Header().Get("X-Total-Count"))
+			}
+			pages = append(pages, fmt.Sprint(ids))
+			cursor := w.Header().Get("X-Next-Cursor")
+			if cursor == "" {
+				break
+			}
+			body = fmt.Sprintf(`{"query":"q","top_k":3,"cursor":%q}`, cursor)
+		}
+		if got := fmt.Sprint(pages); got != "[[0 1 2] [3 4 5] [6]]" {
+			t.Fatalf("unexpected pages %s", got)
+		}
+
+		if _, ids := query(`{"query":"q","top_k":2,"offset":5}`); fmt.Sprint(ids) != "[5 6]" {
+			t.Fatalf("offset 5: expected [5 6], got %v", ids)
+		}
+		// cos(i) = 1/sqrt(1 + i²/16) >= 0.8 for i <= 3.
+		w, ids := query(`{"query":"q","top_k":10,"min_score":0.8}`)
+		if fmt.Sprint(ids) != "[0 1 2 3]" || w.Header().Get("X-Total-Count") != "4" || w.Header().Get("X-Next-Cursor") != "" {
+			t.Fatalf("min_score 0.8: unexpected %v total=%q next=%q", ids, w.Header().Get("X-Total-Count"), w.Header().Get("X-Next-Cursor"))
+		}
+		// Totals are counted, not ranked, in every mode.
+		if w, ids := query(`{"query":"chunk","mode":"keyword","top_k":2}`); len(ids) != 2 || w.Header().Get("X-Total-Count") != "7" {
+			t.Fatalf("keyword: unexpected %v total=%q", ids, w.Header().Get("X-Total-Count"))
+		}
+		if w, ids := query(`{"query":"q","mode":"hybrid","top_k":2}`); len(ids) != 2 || w.Header().Get("X-Total-Count") != "7" {
+			t.Fatalf("hybrid: unexpected %v total=%q", ids, w.Header().Get("X-Total-Count"))
+		}
+		// Fused scores cannot be counted without ranking them.
+		if w, _ := query(`{"query":"q","mode":"hybrid","min_score":0.001}`); w.Header().Get("X-Total-Count") != "" || w.Header().Get("X-Next-Cursor") == "" {
+			t.Fatalf("hybrid min_score: expected no total but a cursor, got %q %q", w.Header().Get("X-Total-Count"), w.Header().Get("X-Next-Cursor"))
+		}
+		if w, ids := query(`{"query":"q","offset":20}`); w.Code != http.StatusOK || len(ids) != 0 {
+			t.Fatalf("past the end: expected an empty page, got %d %v", w.Code, ids)
+		}
+
+		for _, body := range []string{
+			`{"query":"q","top_k":0}`,
+			`{"query":"q","top_k":51}`,
+			`{"query":"q","offset":-1}`,
+			`{"query":"q","offset":999,"top_k":3}`,
+			`{"query":"q","offset":3,"cursor":"b2Zmc2V0OjM"}`,
+			`{"query":"q","cursor":"not-a-cursor"}`,
+		} {
+			if w, _ := query(body); w.Code != http.StatusBadRequest {
+				t.Errorf("%s: expected 400, got %d", body, w.Code)
+			}
+		}
+	})
+
+	t.Run("unknown_mode", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(`{"query":"hello","mode":"fuzzy"}`))
+		w := httptest.NewRecorder()
+		srv.queryHandler(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400, got %d", w.Code)
+		}
+	})
+
 	t.Run("empty_query", func(t *testing.T) {
 		body := `{"query":""}`
 		req := httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(body))
@@ -377,3 +904,410 @@ func TestResetHandler(t *testing.T) {
 		}
 	})
 }
+
+func TestDeleteHandlers(t *testing.T) {
+	seed := func(t *testing.T) *Server {
+		t.Helper()
+		srv := newTestServer()
+		srv.store.Add(
+			rag.Chunk{ID: "a-0", DocumentID: "a", Source: "report.pdf", Embedding: []float64{0.1, 0.2, 0.3}},
+			rag.Chunk{ID: "a-1", DocumentID: "a", Source: "report.pdf", Embedding: []float64{0.1, 0.2, 0.3}},
+			rag.Chunk{ID: "b-0", DocumentID: "b", Source: "report.pdf", Embedding: []float64{0.1, 0.2, 0.3}},
+			rag.Chunk{ID: "c-0", DocumentID: "c", Source: "notes.md", Embedding: []float64{0.1, 0.2, 0.3}},
+		)
+		return srv
+	}
+	deleteDocument := func(srv *Server, id string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodDelete, "/documents/"+id, nil)
+		req.SetPathValue("id", id)
+		w := httptest.NewRecorder()
+		captureLogs(t, func() {
+			srv.documentHandler(w, req)
+		})
+		return w
+	}
+
+	t.Run("by_document_id", func(t *testing.T) {
+		srv := seed(t)
+		w := deleteDocument(srv, "a")
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", w.Code)
+		}
+		var out map[string]any
+		json.NewDecoder(w.Body).Decode(&out)
+		if out["chunks_deleted"] != float64(2) || out["document_id"] != "a" {
+			t.Fatalf("unexpected response %v", out)
+		}
+		if srv.store.HasDocument("a") || srv.store.Count() != 2 {
+			t.Fatalf("expected document a gone and 2 chunks left, got %d", srv.store.Count())
+		}
+		if w := deleteDocument(srv, "a"); w.Code != http.StatusNotFound {
+			t.Fatalf("expected 404 for a missing document, got %d", w.Code)
+		}
+	})
+
+	t.Run("by_source", func(t *testing.T) {
+		srv := seed(t)
+		req := httptest.NewRequest(http.MethodDelete, "/documents?source=report.pdf", nil)
+		w := httptest.NewRecorder()
+		logs := captureLogs(t, func() {
+			srv.documentsHandler(w, req)
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", w.Code)
+		}
+		if !strings.Contains(logs, `delete_source="report.pdf" chunks_deleted=3`) {
+			t.Fatalf("unexpected logs %q", logs)
+		}
+		if left := srv.store.List(); len(left) != 1 || left[0].ID != "c-0" {
+			t.Fatalf("expected only c-0 left, got %+v", left)
+		}
+	})
+
+	t.Run("bad_requests", func(t *testing.T) {
+		srv := seed(t)
+		w := httptest.NewRecorder()
+		srv.documentsHandler(w, httptest.NewRequest(http.MethodDelete, "/documents", nil))
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400 without source, got %d", w.Code)
+		}
+		w = httptest.NewRecorder()
+		srv.documentHandler(w, httptest.NewRequest(http.MethodPost, "/documents/a", nil))
+		if w.Code != http.StatusMethodNotAllowed {
+			t.Fatalf("expected 405, got %d", w.Code)
+		}
+	})
+}
+
+func TestDocumentInspectionHandlers(t *testing.T) {
+	srv := newTestServer()
+	for _, target := range []string{"/upload?id=first&title=First", "/upload?id=second&attr.team=ops"} {
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader("One. Two. Three. Four."))
+		captureLogs(t, func() {
+			srv.uploadHandler(httptest.NewRecorder(), req)
+		})
+	}
+	get := func(handler http.HandlerFunc, target, id string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if id != "" {
+			req.SetPathValue("id", id)
+		}
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+
+	t.Run("list_documents", func(t *testing.T) {
+		w := get(srv.documentsHandler, "/documents", "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", w.Code)
+		}
+		var docs []rag.DocumentInfo
+		if err := json.NewDecoder(w.Body).Decode(&docs); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if len(docs) != 2 || docs[0].ID != "first" || docs[1].ID != "second" {
+			t.Fatalf("expected [first second], got %+v", docs)
+		}
+		if d := docs[0]; d.Source != "First" || d.ChunkCount != 2 || d.Bytes != len("One. Two. Three. Four.") || d.CreatedAt.IsZero() {
+			t.Fatalf("unexpected summary %+v", d)
+		}
+		if docs[1].Attributes["team"] != "ops" {
+			t.Fatalf("expected attributes in summary, got %+v", docs[1])
+		}
+	})
+
+	t.Run("get_document", func(t *testing.T) {
+		w := get(srv.documentHandler, "/documents/first", "first")
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", w.Code)
+		}
+		var doc documentResponse
+		if err := json.NewDecoder(w.Body).Decode(&doc); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if doc.ID != "first" || doc.ChunkCount != 2 || len(doc.Chunks) != 2 {
+			t.Fatalf("unexpected document %+v", doc)
+		}
+		if doc.Chunks[0].Content != "One. Two. Three." || doc.Chunks[1].Content != "Four." {
+			t.Fatalf("expected chunks in order, got %q and %q", doc.Chunks[0].Content, doc.Chunks[1].Content)
+		}
+		if w := get(srv.documentHandler, "/documents/missing", "missing"); w.Code != http.StatusNotFound {
+			t.Fatalf("expected 404, got %d", w.Code)
+		}
+	})
+
+	t.Run("embeddings_on_request", func(t *testing.T) {
+		for _, target := range []string{"/documents/first", "/chunks/first-1"} {
+			handler, id := srv.documentHandler, "first"
+			if strings.HasPrefix(target, "/chunks/") {
+				handler, id = srv.chunkHandler, "first-1"
+			}
+			if w := get(handler, target, id); strings.Contains(w.Body.String(), "Embedding") {
+				t.Fatalf("%s: expected no embeddings by default, got %s", target, w.Body)
+			}
+			if w := get(handler, target+"?embeddings=true", id); !strings.Contains(w.Body.String(), `"Embedding":[0.1,0.2,0.3]`) {
+				t.Fatalf("%s: expected embeddings on request, got %s", target, w.Body)
+			}
+		}
+	})
+
+	t.Run("get_chunk", func(t *testing.T) {
+		w := get(srv.chunkHandler, "/chunks/second-2", "second-2")
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", w.Code)
+		}
+		var ch rag.Chunk
+		if err := json.NewDecoder(w.Body).Decode(&ch); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if ch.DocumentID != "second" || ch.Content != "Four." {
+			t.Fatalf("unexpected chunk %+v", ch)
+		}
+		if w := get(srv.chunkHandler, "/chunks/missing", "missing"); w.Code != http.StatusNotFound {
+			t.Fatalf("expected 404, got %d", w.Code)
+		}
+	})
+
+	t.Run("id_with_slash", func(t *testing.T) {
+		mux := srv.routes()
+		serve := func(method, target string) *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			captureLogs(t, func() {
+				mux.ServeHTTP(w, httptest.NewRequest(method, target, strings.NewReader("Monthly report.")))
+			})
+			return w
+		}
+		if w := serve(http.MethodPost, "/upload?id=team/2025-01"); w.Code != http.StatusOK {
+			t.Fatalf("upload: expected 200, got %d", w.Code)
+		}
+		if w := serve(http.MethodGet, "/documents/team/2025-01"); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"team/2025-01"`) {
+			t.Fatalf("get document: expected 200, got %d: %s", w.Code, w.Body)
+		}
+		if w := serve(http.MethodGet, "/chunks/team/2025-01-1"); w.Code != http.StatusOK {
+			t.Fatalf("get chunk: expected 200, got %d", w.Code)
+		}
+		if w := serve(http.MethodDelete, "/documents/team/2025-01"); w.Code != http.StatusOK {
+			t.Fatalf("delete document: expected 200, got %d", w.Code)
+		}
+		if w := serve(http.MethodGet, "/documents/team/2025-01"); w.Code != http.StatusNotFound {
+			t.Fatalf("expected 404 after delete, got %d", w.Code)
+		}
+	})
+}
+
+func TestCollectionHandlers(t *testing.T) {
+	do := func(t *testing.T, handler http.HandlerFunc, method, target, body string, pathValues ...string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		for i := 0; i+1 < len(pathValues); i += 2 {
+			req.SetPathValue(pathValues[i], pathValues[i+1])
+		}
+		w := httptest.NewRecorder()
+		captureLogs(t, func() {
+			handler(w, req)
+		})
+		return w
+	}
+
+	t.Run("create_list_delete", func(t *testing.T) {
+		srv := newTestServer()
+		if w := do(t, srv.collectionsHandler, http.MethodPost, "/collections", `{"name":"hr"}`); w.Code != http.StatusCreated {
+			t.Fatalf("expected 201, got %d: %s", w.Code, w.Body)
+		}
+		if w := do(t, srv.collectionsHandler, http.MethodPost, "/collections", `{"name":"hr"}`); w.Code != http.StatusConflict {
+			t.Fatalf("expected 409 for a duplicate, got %d", w.Code)
+		}
+		if w := do(t, srv.collectionsHandler, http.MethodPost, "/collections", `{"name":"Bad Name"}`); w.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400 for an invalid name, got %d", w.Code)
+		}
+
+		w := do(t, srv.collectionsHandler, http.MethodGet, "/collections", "")
+		var infos []rag.CollectionInfo
+		json.NewDecoder(w.Body).Decode(&infos)
+		if len(infos) != 2 || infos[0].Name != "default" || infos[1].Name != "hr" {
+			t.Fatalf("unexpected collections %+v", infos)
+		}
+
+		if w := do(t, srv.collectionHandler, http.MethodDelete, "/collections/default", "", "name", "default"); w.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400 deleting default, got %d", w.Code)
+		}
+		if w := do(t, srv.collectionHandler, http.MethodDelete, "/collections/hr", "", "name", "hr"); w.Code != http.StatusNoContent {
+			t.Fatalf("expected 204, got %d", w.Code)
+		}
+		if w := do(t, srv.collectionHandler, http.MethodGet, "/collections/hr", "", "name", "hr"); w.Code != http.StatusNotFound {
+			t.Fatalf("expected 404 after delete, got %d", w.Code)
+		}
+	})
+
+	t.Run("upload_query_reset", func(t *testing.T) {
+		srv := newTestServer()
+		do(t, srv.collectionsHandler, http.MethodPost, "/collections", `{"name":"hr"}`)
+
+		if w := do(t, srv.uploadHandler, http.MethodPost, "/upload?collection=hr&id=policy", "Holidays are paid."); w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+		}
+		if w := do(t, srv.uploadHandler, http.MethodPost, "/upload?id=notes", "Unrelated notes."); w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", w.Code)
+		}
+		if w := do(t, srv.uploadHandler, http.MethodPost, "/upload?collection=missing", "text"); w.Code != http.StatusNotFound {
+			t.Fatalf("expected 404 for an unknown collection, got %d", w.Code)
+		}
+
+		query := func(body string) []rag.SearchResult {
+			t.Helper()
+			w := do(t, srv.queryHandler, http.MethodPost, "/query", body)
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+			}
+			var results []rag.SearchResult
+			json.NewDecoder(w.Body).Decode(&results)
+			return results
+		}
+		if got := query(`{"query":"holidays","collection":"hr"}`); len(got) != 1 || got[0].Chunk.DocumentID != "policy" || got[0].Collection != "hr" {
+			t.Fatalf("expected only the hr document, got %+v", got)
+		}
+		if got := query(`{"query":"holidays"}`); len(got) != 1 || got[0].Chunk.DocumentID != "notes" {
+			t.Fatalf("expected only the default document, got %+v", got)
+		}
+		if got := query(`{"query":"holidays","collections":["default","hr"]}`); len(got) != 2 {
+			t.Fatalf("expected results from both collections, got %+v", got)
+		}
+		if w := do(t, srv.queryHandler, http.MethodPost, "/query", `{"query":"x","collection":"missing"}`); w.Code != http.StatusNotFound {
+			t.Fatalf("expected 404 for an unknown collection, got %d", w.Code)
+		}
+
+		if w := do(t, srv.resetHandler, http.MethodPost, "/reset?collection=hr", ""); w.Code != http.StatusNoContent {
+			t.Fatalf("expected 204, got %d", w.Code)
+		}
+		hr, _ := srv.collections.Get("hr")
+		if hr.Count() != 0 || srv.store.Count() != 1 {
+			t.Fatalf("expected only hr cleared, got hr=%d default=%d", hr.Count(), srv.store.Count())
+		}
+	})
+
+	t.Run("model_mismatch", func(t *testing.T) {
+		srv := newTestServer()
+		do(t, srv.collectionsHandler, http.MethodPost, "/collections", `{"name":"legacy","model":"text-embedding-ada-002"}`)
+		srv.embedder = &rag.SimpleEmbedder{}
+		if w := do(t, srv.uploadHandler, http.MethodPost, "/upload?collection=legacy", "Hello."); w.Code != http.StatusConflict {
+			t.Fatalf("expected 409 for another model, got %d", w.Code)
+		}
+		if w := do(t, srv.queryHandler, http.MethodPost, "/query", `{"query":"hello","collection":"legacy"}`); w.Code != http.StatusConflict {
+			t.Fatalf("expected 409 querying with another model, got %d", w.Code)
+		}
+	})
+}
+
+func TestEmbeddingFailures(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"unavailable", fmt.Errorf("%w: rate limited", rag.ErrEmbedderUnavailable), http.StatusServiceUnavailable},
+		{"timeout", fmt.Errorf("openai: %w", context.DeadlineExceeded), http.StatusServiceUnavailable},
+		{"bad_answer", errors.New("openai: response holds no embedding"), http.StatusBadGateway},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := NewServerWithEmbedder(&errEmbedder{err: tc.err})
+
+			req := httptest.NewRequest(http.MethodPost, "/upload", strings.NewReader("Some text."))
+			w := httptest.NewRecorder()
+			logs := captureLogs(t, func() {
+				srv.uploadHandler(w, req)
+			})
+			if w.Code != tc.want {
+				t.Fatalf("upload: expected %d, got %d", tc.want, w.Code)
+			}
+			if !strings.Contains(logs, "error - embedding failed") {
+				t.Fatalf("expected the failure logged, got %q", logs)
+			}
+			if n := srv.store.Count(); n != 0 {
+				t.Fatalf("expected nothing indexed, got %d chunks", n)
+			}
+
+			req = httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(`{"query":"hello"}`))
+			w = httptest.NewRecorder()
+			captureLogs(t, func() {
+				srv.queryHandler(w, req)
+			})
+			if w.Code != tc.want {
+				t.Fatalf("query: expected %d, got %d", tc.want, w.Code)
+			}
+
+			// Keyword search does not need the embedder.
+			req = httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(`{"query":"hello","mode":"keyword"}`))
+			w = httptest.NewRecorder()
+			captureLogs(t, func() {
+				srv.queryHandler(w, req)
+			})
+			if w.Code != http.StatusOK {
+				t.Fatalf("keyword query: expected 200, got %d", w.Code)
+			}
+		})
+	}
+}
+
+func TestStatsHandler(t *testing.T) {
+	cached, err := rag.NewCachedEmbedder(&FakeEmbedder{}, rag.CacheOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := NewServerWithEmbedder(cached)
+	cached.Embed(context.Background(), "hello")
+	cached.Embed(context.Background(), "hello")
+
+	req := httptest.NewRequest(http.MethodGet, "/stats", nil)
+	w := httptest.NewRecorder()
+	srv.statsHandler(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	var stats serverStats
+	if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if c := stats.EmbeddingCache; c == nil || c.Hits != 1 || c.Misses != 1 {
+		t.Fatalf("unexpected stats %s", w.Body.String())
+	}
+
+	// Without a cache there is nothing to report.
+	w = httptest.NewRecorder()
+	newTestServer().statsHandler(w, req)
+	if strings.TrimSpace(w.Body.String()) != "{}" {
+		t.Fatalf("expected empty stats, got %s", w.Body.String())
+	}
+}
+
+// TestLocalEmbedder runs an upload and a query end to end, offline.
+func TestLocalEmbedder(t *testing.T) {
+	srv := NewServerWithEmbedder(rag.NewLocalEmbedder(rag.LocalOptions{}))
+	srv.minScore = localMinScore
+
+	docs := map[string]string{
+		"vacation": "Employees get 25 days of paid vacation every year.",
+		"expenses": "Travel expenses are reimbursed within two weeks.",
+	}
+	for id, text := range docs {
+		req := httptest.NewRequest(http.MethodPost, "/upload?id="+id, strings.NewReader(text))
+		w := httptest.NewRecorder()
+		captureLogs(t, func() { srv.uploadHandler(w, req) })
+		if w.Code != http.StatusOK {
+			t.Fatalf("upload %s: expected 200, got %d: %s", id, w.Code, w.Body.String())
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(`{"query":"how many vacation days do I get?"}`))
+	w := httptest.NewRecorder()
+	captureLogs(t, func() { srv.queryHandler(w, req) })
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	var results []rag.SearchResult
+	json.NewDecoder(w.Body).Decode(&results)
+	if len(results) == 0 || results[0].Chunk.DocumentID != "vacation" {
+		t.Fatalf("expected the vacation document first, got %+v", results)
+	}
+}
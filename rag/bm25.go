@@ -0,0 +1,300 @@
+package rag
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	defaultBM25K1 = 1.2
+	defaultBM25B  = 0.75
+)
+
+// BM25Options tunes a BM25Index. Zero values use the defaults.
+type BM25Options struct {
+	// K1 controls how quickly repeated terms stop adding to the score.
+	// Default 1.2.
+	K1 float64
+	// B controls how much long chunks are penalized, from 0 (not at all)
+	// to 1 (fully). Default 0.75.
+	B float64
+}
+
+// BM25Index is an inverted index over chunk contents scored with Okapi
+// BM25. It finds chunks by the words they share with a query, so it catches
+// exact identifiers (error codes, SKUs, function names) that embeddings
+// tend to blur. Text is split into terms by Terms.
+//
+// Scores are not bounded like cosine similarities: they grow with the
+// number and rarity of matching terms. Adding a chunk ID that is already
+// indexed replaces the earlier chunk.
+//
+// A BM25Index is safe for concurrent use.
+type BM25Index struct {
+	mu sync.RWMutex
+
+	k1, b float64
+
+	docs     map[string]*bm25Doc       // by chunk ID
+	postings map[string]map[string]int // term -> chunk ID -> term frequency
+	totalLen int                       // sum of the docs' lengths in terms
+	seq      int
+}
+
+type bm25Doc struct {
+	chunk  Chunk
+	seq    int // insertion order, to break ties
+	length int
+	terms  map[string]int
+}
+
+// NewBM25Index returns an empty index.
+func NewBM25Index(opts BM25Options) *BM25Index {
+	if opts.K1 <= 0 {
+		opts.K1 = defaultBM25K1
+	}
+	if opts.B <= 0 || opts.B > 1 {
+		opts.B = defaultBM25B
+	}
+	return &BM25Index{
+		k1:       opts.K1,
+		b:        opts.B,
+		docs:     map[string]*bm25Doc{},
+		postings: map[string]map[string]int{},
+	}
+}
+
+// Len returns the number of indexed chunks.
+func (x *BM25Index) Len() int {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return len(x.docs)
+}
+
+// Add indexes chunks by their Content.
+func (x *BM25Index) Add(chunks ...Chunk) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for _, ch := range chunks {
+		x.remove(ch.ID)
+		doc := &bm25Doc{chunk: ch, seq: x.seq, terms: map[string]int{}}
+		x.seq++
+		for _, term := range Terms(ch.Content) {
+			doc.terms[term]++
+			doc.length++
+		}
+		for term, tf := range doc.terms {
+			p := x.postings[term]
+			if p == nil {
+				p = map[string]int{}
+				x.postings[term] = p
+			}
+			p[ch.ID] = tf
+		}
+		x.docs[ch.ID] = doc
+		x.totalLen += doc.length
+	}
+}
+
+// Delete drops the chunks with the given IDs and returns how many were
+// indexed.
+func (x *BM25Index) Delete(ids ...string) int {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	n := 0
+	for _, id := range ids {
+		if x.remove(id) {
+			n++
+		}
+	}
+	return n
+}
+
+// Reset drops every chunk.
+func (x *BM25Index) Reset() {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.docs, x.postings = map[string]*bm25Doc{}, map[string]map[string]int{}
+	x.totalLen, x.seq = 0, 0
+}
+
+func (x *BM25Index) remove(id string) bool {
+	doc, ok := x.docs[id]
+	if !ok {
+		return false
+	}
+	for term := range doc.terms {
+		p := x.postings[term]
+		delete(p, id)
+		if len(p) == 0 {
+			delete(x.postings, term)
+		}
+	}
+	delete(x.docs, id)
+	x.totalLen -= doc.length
+	return true
+}
+
+// Search returns the topK chunks scoring highest for the query, best
+// first. Chunks sharing no term with the query are never returned, so
+// fewer than topK results may come back.
+func (x *BM25Index) Search(query string, topK int) []SearchResult {
+	return x.SearchFunc(query, topK, nil)
+}
+
+// SearchFunc is Search restricted to chunks for which keep returns true
+// (nil keeps all).
+func (x *BM25Index) SearchFunc(query string, topK int, keep func(Chunk) bool) []SearchResult {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	if topK <= 0 || len(x.docs) == 0 {
+		return []SearchResult{}
+	}
+	scores := x.score(query)
+
+	// Candidates in insertion order, so equal scores keep it.
+	docs := make([]*bm25Doc, 0, len(scores))
+	for doc := range scores {
+		if keep == nil || keep(doc.chunk) {
+			docs = append(docs, doc)
+		}
+	}
+	sort.Slice(docs, func(i, j int) bool { return docs[i].seq < docs[j].seq })
+
+	best := &topKHeap{k: topK}
+	for i, doc := range docs {
+		best.offer(scoredPos{pos: i, score: scores[doc]})
+	}
+	hits := best.sorted()
+	results := make([]SearchResult, len(hits))
+	for i, h := range hits {
+		results[i] = SearchResult{Chunk: docs[h.pos].chunk, Score: h.score}
+	}
+	return results
+}
+
+// matching returns the IDs of the chunks keep accepts (nil keeps all)
+// that score at least minScore for the query, without ranking them.
+func (x *BM25Index) matching(query string, minScore float64, keep func(Chunk) bool) map[string]bool {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	ids := map[string]bool{}
+	for doc, score := range x.score(query) {
+		if score >= minScore && (keep == nil || keep(doc.chunk)) {
+			ids[doc.chunk.ID] = true
+		}
+	}
+	return ids
+}
+
+// score returns the BM25 score of every chunk sharing a term with the
+// query. The caller holds x.mu.
+func (x *BM25Index) score(query string) map[*bm25Doc]float64 {
+	scores := map[*bm25Doc]float64{}
+	if len(x.docs) == 0 {
+		return scores
+	}
+	n := float64(len(x.docs))
+	avgLen := float64(x.totalLen) / n
+	seen := map[string]bool{}
+	for _, term := range Terms(query) {
+		if seen[term] {
+			continue
+		}
+		seen[term] = true
+		p := x.postings[term]
+		if len(p) == 0 {
+			continue
+		}
+		df := float64(len(p))
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+		for id, tf := range p {
+			doc := x.docs[id]
+			norm := 1 - x.b
+			if avgLen > 0 {
+				norm += x.b * float64(doc.length) / avgLen
+			}
+			f := float64(tf)
+			scores[doc] += idf * f * (x.k1 + 1) / (f + x.k1*norm)
+		}
+	}
+	return scores
+}
+
+// Terms splits text into the terms BM25Index matches on. Text is lower
+// cased and cut into runs of letters and digits. Runs joined by "-", "_"
+// or "." form one identifier ("ERR-404" gives "err-404"), which is kept
+// whole as well as split into its parts, so a query for either form
+// matches. Plain words are reduced to a rough stem ("policies" and
+// "policy" both give "policy", "indexing" and "indexed" give "index");
+// anything containing a digit is left as written.
+func Terms(text string) []string {
+	isJoiner := func(r rune) bool { return r == '-' || r == '_' || r == '.' }
+	fields := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && !isJoiner(r)
+	})
+
+	var terms []string
+	for _, f := range fields {
+		f = strings.TrimFunc(f, isJoiner)
+		if f == "" {
+			continue
+		}
+		parts := strings.FieldsFunc(f, isJoiner)
+		if len(parts) > 1 {
+			terms = append(terms, f)
+		}
+		for _, p := range parts {
+			terms = append(terms, stem(p))
+		}
+	}
+	return terms
+}
+
+// stem strips common English inflections from a lower-case word: plural
+// "s", "ing", "ed" and a final "e". It is far cruder than a real stemmer
+// but maps the usual variants of a word onto one term.
+func stem(w string) string {
+	for _, r := range w {
+		if !unicode.IsLetter(r) {
+			return w
+		}
+	}
+	if len(w) <= 3 {
+		return w
+	}
+
+	switch {
+	case strings.HasSuffix(w, "sses"):
+		w = w[:len(w)-2]
+	case strings.HasSuffix(w, "ies") && len(w) > 4:
+		w = w[:len(w)-3] + "y"
+	case strings.HasSuffix(w, "s") && !strings.HasSuffix(w, "ss") &&
+		!strings.HasSuffix(w, "us") && !strings.HasSuffix(w, "is"):
+		w = w[:len(w)-1]
+	}
+
+	for _, suffix := range []string{"ing", "ed"} {
+		base, ok := strings.CutSuffix(w, suffix)
+		if !ok || len(base) < 3 || !strings.ContainsAny(base, "aeiouy") {
+			continue
+		}
+		// running -> run, but falling -> fall and missed -> miss.
+		if n := len(base); base[n-1] < utf8.RuneSelf && base[n-1] == base[n-2] && !strings.ContainsRune("lsz", rune(base[n-1])) {
+			base = base[:n-1]
+		}
+		w = base
+		break
+	}
+
+	if len(w) > 4 && strings.HasSuffix(w, "e") {
+		w = w[:len(w)-1]
+	}
+	return w
+}
@@ -0,0 +1,88 @@
+package rag
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestTerms(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"Hello, World!", "[hello world]"},
+		{"Policies and policy", "[policy and policy]"},
+		{"indexing indexed indexes index", "[index index index index]"},
+		{"Running stopped falling", "[run stop fall]"},
+		{"Error ERR-404 in SKU_12345.", "[error err-404 err 404 in sku_12345 sku 12345]"},
+		{"version 1.2.3", "[version 1.2.3 1 2 3]"},
+		{"Crème BRÛLÉE", "[crèm brûlé]"},
+		{"   ", "[]"},
+	}
+	for _, tt := range tests {
+		if got := fmt.Sprint(Terms(tt.text)); got != tt.want {
+			t.Errorf("Terms(%q) = %s, want %s", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestBM25Index_Ranking(t *testing.T) {
+	x := NewBM25Index(BM25Options{})
+	x.Add(
+		Chunk{ID: "common", Content: "the service returned an error"},
+		Chunk{ID: "rare", Content: "the service returned error E1234"},
+		Chunk{ID: "long", Content: "error " + "padding words that say nothing useful at all, " + "more padding words that say nothing useful at all"},
+	)
+
+	// The rare identifier outweighs the common word.
+	got := x.Search("error E1234", 3)
+	if len(got) != 3 || got[0].Chunk.ID != "rare" {
+		t.Fatalf("expected rare first, got %+v", got)
+	}
+	// Length normalization: the same single match scores lower in a long chunk.
+	if got[1].Chunk.ID != "common" || got[1].Score <= got[2].Score {
+		t.Fatalf("expected common before long, got %s (%.3f), %s (%.3f)", got[1].Chunk.ID, got[1].Score, got[2].Chunk.ID, got[2].Score)
+	}
+}
+
+func TestBM25Index_TiesKeepInsertionOrder(t *testing.T) {
+	x := NewBM25Index(BM25Options{})
+	for i := range 10 {
+		x.Add(Chunk{ID: fmt.Sprint(i), Content: "same words"})
+	}
+	got := x.Search("words", 3)
+	if len(got) != 3 || got[0].Chunk.ID != "0" || got[1].Chunk.ID != "1" || got[2].Chunk.ID != "2" {
+		t.Fatalf("expected [0 1 2], got %+v", got)
+	}
+}
+
+func TestBM25Index_ReplaceDeleteReset(t *testing.T) {
+	x := NewBM25Index(BM25Options{})
+	x.Add(Chunk{ID: "a", Content: "apples"}, Chunk{ID: "b", Content: "bananas"})
+	x.Add(Chunk{ID: "a", Content: "cherries"})
+
+	if x.Len() != 2 {
+		t.Fatalf("expected 2 chunks, got %d", x.Len())
+	}
+	if got := x.Search("apple", 5); len(got) != 0 {
+		t.Fatalf("replaced content still matches: %+v", got)
+	}
+	if got := x.Search("cherry", 5); len(got) != 1 || got[0].Chunk.ID != "a" {
+		t.Fatalf("expected a for cherry, got %+v", got)
+	}
+
+	if n := x.Delete("b", "missing"); n != 1 {
+		t.Fatalf("expected 1 deleted, got %d", n)
+	}
+	if got := x.Search("banana", 5); len(got) != 0 {
+		t.Fatalf("deleted chunk still matches: %+v", got)
+	}
+	if len(x.postings) != 1 {
+		t.Fatalf("expected postings of deleted terms dropped, got %v", x.postings)
+	}
+
+	x.Reset()
+	if x.Len() != 0 || len(x.Search("cherry", 5)) != 0 {
+		t.Fatal("expected an empty index after Reset")
+	}
+}
@@ -0,0 +1,245 @@
+package rag
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+)
+
+const defaultCacheSize = 10_000
+
+// CacheOptions tunes a CachedEmbedder.
+type CacheOptions struct {
+	// Size is how many vectors the in-memory LRU keeps. Default 10000.
+	Size int
+	// Dir, when set, keeps every vector on disk as well, so the cache
+	// survives restarts. It is created if needed.
+	Dir string
+}
+
+// CacheStats counts cache lookups since the embedder was created.
+type CacheStats struct {
+	Hits     int64 // served from memory
+	DiskHits int64 // served from disk
+	Misses   int64 // sent to the wrapped embedder
+	Entries  int   // vectors in memory
+}
+
+// CachedEmbedder wraps an Embedder and remembers its vectors, keyed by the
+// SHA-256 of the model name and the text, so re-uploading a document or
+// repeating a query costs nothing. Recently used vectors stay in memory
+// (LRU); with CacheOptions.Dir every vector is also written to disk.
+// Failed embeddings are not cached.
+//
+// It passes on the wrapped embedder's Model, and embeds misses in one
+// batch when the wrapped embedder is a BatchEmbedder.
+type CachedEmbedder struct {
+	next  Embedder
+	model string
+	dir   string
+	size  int
+
+	mu    sync.Mutex
+	lru   *list.List // of *cacheEntry, most recent first
+	items map[string]*list.Element
+
+	hits, diskHits, misses atomic.Int64
+}
+
+type cacheEntry struct {
+	key string
+	vec []float64
+}
+
+// NewCachedEmbedder wraps next. It fails only if opts.Dir cannot be created.
+func NewCachedEmbedder(next Embedder, opts CacheOptions) (*CachedEmbedder, error) {
+	if opts.Size <= 0 {
+		opts.Size = defaultCacheSize
+	}
+	if opts.Dir != "" {
+		if err := os.MkdirAll(opts.Dir, 0o755); err != nil {
+			return nil, fmt.Errorf("create cache dir: %w", err)
+		}
+	}
+	return &CachedEmbedder{
+		next:  next,
+		model: EmbedderModel(next),
+		dir:   opts.Dir,
+		size:  opts.Size,
+		lru:   list.New(),
+		items: map[string]*list.Element{},
+	}, nil
+}
+
+func (c *CachedEmbedder) Model() string { return c.model }
+
+// Stats returns the lookup counters.
+func (c *CachedEmbedder) Stats() CacheStats {
+	c.mu.Lock()
+	entries := c.lru.Len()
+	c.mu.Unlock()
+	return CacheStats{
+		Hits:     c.hits.Load(),
+		DiskHits: c.diskHits.Load(),
+		Misses:   c.misses.Load(),
+		Entries:  entries,
+	}
+}
+
+func (c *CachedEmbedder) Embed(ctx context.Context, text string) ([]float64, error) {
+	key := c.key(text)
+	if vec, ok := c.lookup(key); ok {
+		return vec, nil
+	}
+	vec, err := c.next.Embed(ctx, text)
+	if err != nil {
+		return nil, err
+	}
+	c.store(key, vec)
+	return vec, nil
+}
+
+// EmbedBatch serves what it can from the cache and embeds the rest
+// together (see EmbedAll).
+func (c *CachedEmbedder) EmbedBatch(ctx context.Context, texts []string) ([][]float64, error) {
+	out := make([][]float64, len(texts))
+	keys := make([]string, len(texts))
+	var missing []string
+	var missingAt []int
+	for i, text := range texts {
+		keys[i] = c.key(text)
+		if vec, ok := c.lookup(keys[i]); ok {
+			out[i] = vec
+			continue
+		}
+		missing = append(missing, text)
+		missingAt = append(missingAt, i)
+	}
+
+	vecs, err := EmbedAll(ctx, c.next, missing)
+	if err != nil {
+		return nil, err
+	}
+	for j, i := range missingAt {
+		out[i] = vecs[j]
+		c.store(keys[i], vecs[j])
+	}
+	return out, nil
+}
+
+// key is the hex SHA-256 of the model and the text. The model name is
+// length-prefixed so no (model, text) pair can collide with another.
+func (c *CachedEmbedder) key(text string) string {
+	h := sha256.New()
+	var n [8]byte
+	binary.LittleEndian.PutUint64(n[:], uint64(len(c.model)))
+	h.Write(n[:])
+	h.Write([]byte(c.model))
+	h.Write([]byte(text))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// lookup checks memory, then disk, and counts the outcome. Vectors are
+// shared, so callers must not modify them.
+func (c *CachedEmbedder) lookup(key string) ([]float64, bool) {
+	c.mu.Lock()
+	if el, ok := c.items[key]; ok {
+		c.lru.MoveToFront(el)
+		c.mu.Unlock()
+		c.hits.Add(1)
+		return el.Value.(*cacheEntry).vec, true
+	}
+	c.mu.Unlock()
+
+	if vec, ok := c.readDisk(key); ok {
+		c.diskHits.Add(1)
+		c.remember(key, vec)
+		return vec, true
+	}
+	c.misses.Add(1)
+	return nil, false
+}
+
+// store caches a freshly embedded vector in memory and on disk.
+func (c *CachedEmbedder) store(key string, vec []float64) {
+	c.remember(key, vec)
+	if c.dir != "" {
+		// The disk tier is best effort: a vector that cannot be written
+		// is simply embedded again after a restart.
+		c.writeDisk(key, vec)
+	}
+}
+
+// remember puts a vector in the LRU, evicting the least recently used.
+func (c *CachedEmbedder) remember(key string, vec []float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.lru.PushFront(&cacheEntry{key: key, vec: vec})
+	for c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.items, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// path spreads files over 256 subdirectories.
+func (c *CachedEmbedder) path(key string) string {
+	return filepath.Join(c.dir, key[:2], key+".vec")
+}
+
+// readDisk loads a vector written by writeDisk: little-endian float64s.
+// A missing or damaged file is a miss.
+func (c *CachedEmbedder) readDisk(key string) ([]float64, bool) {
+	if c.dir == "" {
+		return nil, false
+	}
+	data, err := os.ReadFile(c.path(key))
+	if err != nil || len(data) == 0 || len(data)%8 != 0 {
+		return nil, false
+	}
+	vec := make([]float64, len(data)/8)
+	for i := range vec {
+		vec[i] = math.Float64frombits(binary.LittleEndian.Uint64(data[i*8:]))
+	}
+	return vec, true
+}
+
+func (c *CachedEmbedder) writeDisk(key string, vec []float64) error {
+	data := make([]byte, 8*len(vec))
+	for i, x := range vec {
+		binary.LittleEndian.PutUint64(data[i*8:], math.Float64bits(x))
+	}
+	path := c.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	// Write then rename, so readers never see half a vector.
+	tmp, err := os.CreateTemp(filepath.Dir(path), key+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, werr := tmp.Write(data)
+	cerr := tmp.Close()
+	if err := errors.Join(werr, cerr); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package rag
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// recordingEmbedder embeds a text as {its length} and records every text
+// it is asked for.
+type recordingEmbedder struct {
+	model string
+	calls []string
+	fail  bool
+}
+
+func (r *recordingEmbedder) Model() string { return r.model }
+
+func (r *recordingEmbedder) Embed(ctx context.Context, text string) ([]float64, error) {
+	r.calls = append(r.calls, text)
+	if r.fail {
+		return nil, ErrEmbedderUnavailable
+	}
+	return []float64{float64(len(text))}, nil
+}
+
+func TestCachedEmbedder_HitsAndMisses(t *testing.T) {
+	next := &recordingEmbedder{model: "m1"}
+	c, err := NewCachedEmbedder(next, CacheOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	for range 3 {
+		if v, err := c.Embed(ctx, "hello"); err != nil || v[0] != 5 {
+			t.Fatalf("Embed: got %v, %v", v, err)
+		}
+	}
+	if len(next.calls) != 1 {
+		t.Fatalf("expected one embedding call, got %v", next.calls)
+	}
+	if s := c.Stats(); s.Hits != 2 || s.Misses != 1 || s.Entries != 1 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+	if c.Model() != "m1" {
+		t.Fatalf("expected the wrapped model, got %q", c.Model())
+	}
+
+	next.fail = true
+	if _, err := c.Embed(ctx, "new text"); !errors.Is(err, ErrEmbedderUnavailable) {
+		t.Fatalf("expected the wrapped error, got %v", err)
+	}
+	next.fail = false
+	c.Embed(ctx, "new text")
+	if n := len(next.calls); n != 3 {
+		t.Fatalf("expected a failed embedding not to be cached, got calls %v", next.calls)
+	}
+}
+
+func TestCachedEmbedder_KeyIncludesModel(t *testing.T) {
+	a, _ := NewCachedEmbedder(&recordingEmbedder{model: "ab"}, CacheOptions{})
+	b, _ := NewCachedEmbedder(&recordingEmbedder{model: "a"}, CacheOptions{})
+	if a.key("c") == b.key("bc") {
+		t.Fatal("(ab, c) and (a, bc) share a key")
+	}
+	if a.key("c") != a.key("c") {
+		t.Fatal("keys are not stable")
+	}
+}
+
+func TestCachedEmbedder_EvictsLeastRecentlyUsed(t *testing.T) {
+	next := &recordingEmbedder{}
+	c, _ := NewCachedEmbedder(next, CacheOptions{Size: 2})
+	ctx := context.Background()
+
+	c.Embed(ctx, "a")
+	c.Embed(ctx, "b")
+	c.Embed(ctx, "a") // a is now the most recent
+	c.Embed(ctx, "c") // evicts b
+	next.calls = nil
+	c.Embed(ctx, "a")
+	c.Embed(ctx, "b")
+	if fmt.Sprint(next.calls) != "[b]" {
+		t.Fatalf("expected only b re-embedded, got %v", next.calls)
+	}
+	if s := c.Stats(); s.Entries != 2 {
+		t.Fatalf("expected 2 entries, got %d", s.Entries)
+	}
+}
+
+func TestCachedEmbedder_Batch(t *testing.T) {
+	next := &countingEmbedder{}
+	c, _ := NewCachedEmbedder(next, CacheOptions{})
+	ctx := context.Background()
+
+	if _, err := c.EmbedBatch(ctx, []string{"x", "y"}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.EmbedBatch(ctx, []string{"y", "z", "x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// x and y come from the cache; z alone is embedded, in a second batch.
+	if next.batches != 2 || fmt.Sprint(got) != "[[1] [0] [0]]" {
+		t.Fatalf("unexpected %d batches, vectors %v", next.batches, got)
+	}
+	if s := c.Stats(); s.Hits != 2 || s.Misses != 3 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+}
+
+func TestCachedEmbedder_DiskTier(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	first, err := NewCachedEmbedder(&recordingEmbedder{model: "m"}, CacheOptions{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first.Embed(ctx, "persisted")
+
+	// A new cache, as after a restart, finds the vector on disk.
+	next := &recordingEmbedder{model: "m"}
+	second, _ := NewCachedEmbedder(next, CacheOptions{Dir: dir})
+	v, err := second.Embed(ctx, "persisted")
+	if err != nil || v[0] != 9 || len(next.calls) != 0 {
+		t.Fatalf("expected the vector from disk, got %v, %v after calls %v", v, err, next.calls)
+	}
+	if s := second.Stats(); s.DiskHits != 1 || s.Misses != 0 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+	second.Embed(ctx, "persisted")
+	if s := second.Stats(); s.Hits != 1 {
+		t.Fatalf("expected the disk hit promoted to memory, got %+v", s)
+	}
+
+	// A damaged file is a miss, not an error.
+	key := second.key("persisted")
+	os.WriteFile(filepath.Join(dir, key[:2], key+".vec"), []byte("bad"), 0o644)
+	third, _ := NewCachedEmbedder(next, CacheOptions{Dir: dir})
+	if v, err := third.Embed(ctx, "persisted"); err != nil || v[0] != 9 || len(next.calls) != 1 {
+		t.Fatalf("expected a re-embed after damage, got %v, %v", v, err)
+	}
+}
@@ -1,46 +1,383 @@
 package rag
 
 import (
-    "strconv"
-    "strings"
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
-// Very naive chunker by number of sentences
-func ChunkText(text, source string, embedder Embedder) []Chunk {
-	sentences := strings.Split(text, ".")
-	const maxSentencesPerChunk = 3
+// Segment is a piece of a document produced by a Chunker, before it is embedded.
+type Segment struct {
+	Content  string
+	Metadata map[string]string // copied to Chunk.Metadata
 
-	var chunks []Chunk
-	var buffer []string
+	// Byte offsets of Content in the text given to Split.
+	Start int
+	End   int
+
+	// 1-based line range, set by chunkers that work on source code.
+	StartLine int
+	EndLine   int
+}
+
+// Chunker splits a document into segments. Different documents need different
+// strategies, so the server picks one per upload.
+type Chunker interface {
+	Split(text string) []Segment
+}
+
+// ContextChunker is implemented by chunkers that call out to other
+// services while splitting (the semantic chunker embeds sentences), so
+// they can be cancelled and report failures. ChunkDocument prefers it.
+type ContextChunker interface {
+	SplitContext(ctx context.Context, text string) ([]Segment, error)
+}
+
+// split uses SplitContext when c has it.
+func split(ctx context.Context, c Chunker, text string) ([]Segment, error) {
+	if cc, ok := c.(ContextChunker); ok {
+		return cc.SplitContext(ctx, text)
+	}
+	return c.Split(text), nil
+}
+
+// ChunkerOptions tunes the strategies returned by NewChunker.
+// Zero values fall back to each strategy's default.
+type ChunkerOptions struct {
+	// Size is the strategy's unit of length: sentences, characters or tokens.
+	Size int
+	// Overlap is how many tokens consecutive token windows share.
+	Overlap int
+	// Embedder is used by strategies that look at meaning (semantic).
+	Embedder Embedder
+}
 
-	maybeFlush := func() {
-		if len(buffer) == 0 {
-			return
+// chunkerFactories holds every strategy selectable by name.
+var chunkerFactories = map[string]func(ChunkerOptions) Chunker{
+	"sentence": func(o ChunkerOptions) Chunker {
+		return &SentenceChunker{MaxSentences: o.Size}
+	},
+	"fixed": func(o ChunkerOptions) Chunker {
+		return &FixedSizeChunker{Size: o.Size}
+	},
+	"token": func(o ChunkerOptions) Chunker {
+		return &TokenChunker{MaxTokens: o.Size, Overlap: o.Overlap}
+	},
+	"paragraph": func(o ChunkerOptions) Chunker {
+		return &ParagraphChunker{MaxChars: o.Size}
+	},
+	"markdown": func(o ChunkerOptions) Chunker {
+		return &MarkdownChunker{MaxChars: o.Size}
+	},
+	"code": func(o ChunkerOptions) Chunker {
+		return &CodeChunker{MaxChars: o.Size}
+	},
+	"semantic": func(o ChunkerOptions) Chunker {
+		return &SemanticChunker{Embedder: o.Embedder, MaxChars: o.Size}
+	},
+}
+
+// DefaultChunker is the strategy used when none is requested.
+const DefaultChunker = "sentence"
+
+// NewChunker returns the strategy registered under name ("" means DefaultChunker).
+func NewChunker(name string, opts ChunkerOptions) (Chunker, error) {
+	if name == "" {
+		name = DefaultChunker
+	}
+	if opts.Size < 0 {
+		return nil, fmt.Errorf("invalid chunk size %d", opts.Size)
+	}
+	if opts.Overlap < 0 {
+		return nil, fmt.Errorf("invalid chunk overlap %d", opts.Overlap)
+	}
+	if name == "token" && opts.Overlap > 0 {
+		window := opts.Size
+		if window == 0 {
+			window = defaultMaxTokens
 		}
-		content := strings.TrimSpace(strings.Join(buffer, ". ") + ".")
-		if content == "." {
-			return
+		if opts.Overlap >= window {
+			return nil, fmt.Errorf("chunk overlap %d must be smaller than the %d token window", opts.Overlap, window)
+		}
+	}
+	if name == "semantic" && opts.Embedder == nil {
+		return nil, fmt.Errorf("semantic chunker needs an embedder")
+	}
+	factory, ok := chunkerFactories[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown chunker %q (available: %s)", name, strings.Join(ChunkerNames(), ", "))
+	}
+	return factory(opts), nil
+}
+
+// ChunkerNames lists the registered strategies in alphabetical order.
+func ChunkerNames() []string {
+	names := make([]string, 0, len(chunkerFactories))
+	for name := range chunkerFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// segmentAt returns the segment covering text[start:end].
+func segmentAt(text string, start, end int) Segment {
+	return Segment{Content: text[start:end], Start: start, End: end}
+}
+
+// ChunkDocument splits a document with the given chunker and embeds every
+// segment: SplitDocument followed by EmbedChunks.
+func ChunkDocument(ctx context.Context, c Chunker, doc Document, embedder Embedder) ([]Chunk, error) {
+	chunks, err := SplitDocument(ctx, c, doc)
+	if err != nil {
+		return nil, err
+	}
+	if err := EmbedChunks(ctx, embedder, chunks); err != nil {
+		return nil, err
+	}
+	return chunks, nil
+}
+
+// SplitDocument splits a document with the given chunker into chunks
+// without embeddings, so callers can look at them before paying for
+// those. Chunks are numbered in document order and share one creation
+// time; blank segments are dropped.
+func SplitDocument(ctx context.Context, c Chunker, doc Document) ([]Chunk, error) {
+	if doc.Source == "" {
+		doc.Source = doc.ID
+	}
+	createdAt := time.Now().UTC()
+
+	segments, err := split(ctx, c, doc.Text)
+	if err != nil {
+		return nil, err
+	}
+	var chunks []Chunk
+	for _, seg := range segments {
+		content := strings.TrimSpace(seg.Content)
+		if content == "" {
+			continue
 		}
+		start := seg.Start + len(seg.Content) - len(strings.TrimLeftFunc(seg.Content, unicode.IsSpace))
 		chunks = append(chunks, Chunk{
-            ID:        source + "-" + strconv.Itoa(len(chunks)+1),
-			Content:   content,
-			Source:    source,
-			Embedding: embedder.Embed(content),
+			ID:         doc.ID + "-" + strconv.Itoa(len(chunks)+1),
+			DocumentID: doc.ID,
+			Index:      len(chunks),
+			Content:    content,
+			Source:     doc.Source,
+			Metadata:   seg.Metadata,
+			Attributes: doc.Attributes,
+			Start:      start,
+			End:        start + len(content),
+			Page:       pageAt(doc.Pages, start),
+			StartLine:  seg.StartLine,
+			EndLine:    seg.EndLine,
+			CreatedAt:  createdAt,
 		})
-		buffer = []string{}
 	}
+	return chunks, nil
+}
 
-	for _, s := range sentences {
-		s = strings.TrimSpace(s)
-		if s == "" {
-			continue
+// EmbedChunks sets the Embedding and Model of every chunk. Contents are
+// embedded together, in batches when the embedder supports it (see
+// EmbedAll). If any chunk cannot be embedded it returns the error and
+// leaves the chunks as they were, so a document is never indexed with
+// missing vectors.
+func EmbedChunks(ctx context.Context, embedder Embedder, chunks []Chunk) error {
+	contents := make([]string, len(chunks))
+	for i, ch := range chunks {
+		contents[i] = ch.Content
+	}
+	embeddings, err := EmbedAll(ctx, embedder, contents)
+	if err != nil {
+		return fmt.Errorf("embed chunks: %w", err)
+	}
+	model := EmbedderModel(embedder)
+	for i := range chunks {
+		chunks[i].Embedding = embeddings[i]
+		chunks[i].Model = model
+	}
+	return nil
+}
+
+// pageAt returns the 1-based page containing offset, or 0 without pages.
+func pageAt(pages []int, offset int) int {
+	return sort.Search(len(pages), func(i int) bool { return pages[i] > offset })
+}
+
+// ChunkWith chunks text whose document ID is its source.
+func ChunkWith(ctx context.Context, c Chunker, text, source string, embedder Embedder) ([]Chunk, error) {
+	return ChunkDocument(ctx, c, Document{ID: source, Text: text}, embedder)
+}
+
+// ChunkText chunks text with the default sentence strategy.
+func ChunkText(ctx context.Context, text, source string, embedder Embedder) ([]Chunk, error) {
+	return ChunkWith(ctx, &SentenceChunker{}, text, source, embedder)
+}
+
+// ---- Sentence chunker ----
+
+const defaultMaxSentences = 3
+
+// SentenceChunker groups up to MaxSentences sentences per segment.
+// Sentences come from SplitSentences, so segments are exact slices of
+// the original text.
+type SentenceChunker struct {
+	MaxSentences int
+}
+
+func (c *SentenceChunker) Split(text string) []Segment {
+	maxSentences := c.MaxSentences
+	if maxSentences <= 0 {
+		maxSentences = defaultMaxSentences
+	}
+
+	sentences := SplitSentences(text)
+	var segments []Segment
+	for i := 0; i < len(sentences); i += maxSentences {
+		last := min(i+maxSentences, len(sentences)) - 1
+		segments = append(segments, segmentAt(text, sentences[i].Start, sentences[last].End))
+	}
+	return segments
+}
+
+// ---- Fixed-size character window ----
+
+const defaultWindowChars = 500
+
+// FixedSizeChunker cuts text into windows of Size characters, ignoring
+// any structure. Useful for text without reliable punctuation.
+type FixedSizeChunker struct {
+	Size int
+}
+
+func (c *FixedSizeChunker) Split(text string) []Segment {
+	size := c.Size
+	if size <= 0 {
+		size = defaultWindowChars
+	}
+
+	var segments []Segment
+	for start := 0; start < len(text); {
+		end, n := start, 0
+		for end < len(text) && n < size {
+			_, w := utf8.DecodeRuneInString(text[end:])
+			end += w
+			n++
 		}
-		buffer = append(buffer, s)
-		if len(buffer) >= maxSentencesPerChunk {
-			maybeFlush()
+		segments = append(segments, segmentAt(text, start, end))
+		start = end
+	}
+	return segments
+}
+
+// ---- Token window ----
+
+const defaultMaxTokens = 200
+
+// TokenChunker cuts text into windows of at most MaxTokens tokens, each
+// window repeating the last Overlap tokens of the previous one so that
+// sentences straddling a boundary are still retrievable as a whole.
+// Windows are slices of the original text, whitespace included.
+type TokenChunker struct {
+	MaxTokens int
+	Overlap   int
+	Tokenizer Tokenizer // defaults to ApproxTokenizer
+}
+
+func (c *TokenChunker) Split(text string) []Segment {
+	maxTokens := c.MaxTokens
+	if maxTokens <= 0 {
+		maxTokens = defaultMaxTokens
+	}
+	overlap := c.Overlap
+	if overlap < 0 || overlap >= maxTokens {
+		overlap = 0
+	}
+	tokenizer := c.Tokenizer
+	if tokenizer == nil {
+		tokenizer = ApproxTokenizer{}
+	}
+
+	tokens := tokenizer.Tokenize(text)
+	var segments []Segment
+	for start := 0; start < len(tokens); start += maxTokens - overlap {
+		end := min(start+maxTokens, len(tokens))
+		segments = append(segments, segmentAt(text, tokens[start].Start, tokens[end-1].End))
+		if end == len(tokens) {
+			break
 		}
 	}
-	maybeFlush()
+	return segments
+}
+
+// ---- Paragraph chunker ----
+
+const defaultParagraphChars = 1000
+
+// ParagraphChunker splits on blank lines and merges consecutive short
+// paragraphs while they fit in MaxChars. A single paragraph longer than
+// MaxChars is kept whole.
+type ParagraphChunker struct {
+	MaxChars int
+}
+
+func (c *ParagraphChunker) Split(text string) []Segment {
+	maxChars := c.MaxChars
+	if maxChars <= 0 {
+		maxChars = defaultParagraphChars
+	}
 
-	return chunks
+	var segments []Segment
+	first, last := -1, -1
+	for _, p := range splitParagraphs(text) {
+		if first >= 0 && p.End-first > maxChars {
+			segments = append(segments, segmentAt(text, first, last))
+			first = -1
+		}
+		if first < 0 {
+			first = p.Start
+		}
+		last = p.End
+	}
+	if first >= 0 {
+		segments = append(segments, segmentAt(text, first, last))
+	}
+
+	return segments
+}
+
+// splitParagraphs returns the spans of the non-blank blocks separated by
+// blank lines, without their surrounding whitespace.
+func splitParagraphs(text string) []Span {
+	var paragraphs []Span
+	start, end := -1, -1
+	for pos := 0; pos < len(text); {
+		next := len(text)
+		if i := strings.IndexByte(text[pos:], '\n'); i >= 0 {
+			next = pos + i + 1
+		}
+		line := text[pos:next]
+		if trimmed := strings.TrimSpace(line); trimmed == "" {
+			if start >= 0 {
+				paragraphs = append(paragraphs, Span{start, end})
+				start = -1
+			}
+		} else {
+			if start < 0 {
+				start = pos + len(line) - len(strings.TrimLeftFunc(line, unicode.IsSpace))
+			}
+			end = pos + len(strings.TrimRightFunc(line, unicode.IsSpace))
+		}
+		pos = next
+	}
+	if start >= 0 {
+		paragraphs = append(paragraphs, Span{start, end})
+	}
+	return paragraphs
 }
@@ -1,18 +1,40 @@
 package rag
 
-import "testing"
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
 
 type fakeEmbedder struct{}
 
-func (f *fakeEmbedder) Embed(text string) []float64 {
-	return []float64{1} // dummy
+func (f *fakeEmbedder) Embed(ctx context.Context, text string) ([]float64, error) {
+	return []float64{1}, nil // dummy
+}
+
+// failingEmbedder fails every call after the first ok ones.
+type failingEmbedder struct {
+	ok    int
+	calls int
+}
+
+func (f *failingEmbedder) Embed(ctx context.Context, text string) ([]float64, error) {
+	f.calls++
+	if f.calls > f.ok {
+		return nil, ErrEmbedderUnavailable
+	}
+	return []float64{1}, nil
 }
 
 func TestChunkText_SplitsSentences(t *testing.T) {
 	text := "Sentence one. Sentence two. Sentence three. Sentence four."
 
 	e := &fakeEmbedder{}
-	chunks := ChunkText(text, "test-doc", e)
+	chunks, err := ChunkText(context.Background(), text, "test-doc", e)
+	if err != nil {
+		t.Fatalf("chunking failed: %v", err)
+	}
 
 	if len(chunks) == 0 {
 		t.Fatalf("expected at least one chunk")
@@ -34,9 +56,209 @@ func TestChunkText_SplitsSentences(t *testing.T) {
 
 func TestChunkText_EmptyInput(t *testing.T) {
 	e := &fakeEmbedder{}
-	chunks := ChunkText("", "empty", e)
+	chunks, err := ChunkText(context.Background(), "", "empty", e)
+	if err != nil {
+		t.Fatalf("chunking failed: %v", err)
+	}
 
 	if len(chunks) != 0 {
 		t.Fatalf("expected 0 chunks for empty input, got %d", len(chunks))
 	}
 }
+
+func TestNewChunker_Strategies(t *testing.T) {
+	for _, name := range []string{"", "sentence", "fixed", "token", "paragraph", "markdown", "code", "semantic"} {
+		c, err := NewChunker(name, ChunkerOptions{Embedder: &fakeEmbedder{}})
+		if err != nil {
+			t.Fatalf("NewChunker(%q) returned error: %v", name, err)
+		}
+		if c == nil {
+			t.Fatalf("NewChunker(%q) returned nil chunker", name)
+		}
+	}
+}
+
+func TestNewChunker_Invalid(t *testing.T) {
+	if _, err := NewChunker("bogus", ChunkerOptions{}); err == nil {
+		t.Fatalf("expected error for unknown chunker")
+	}
+	if _, err := NewChunker("fixed", ChunkerOptions{Size: -1}); err == nil {
+		t.Fatalf("expected error for negative size")
+	}
+}
+
+func TestFixedSizeChunker_Windows(t *testing.T) {
+	c := &FixedSizeChunker{Size: 4}
+	segs := c.Split("abcdefghij")
+
+	want := []string{"abcd", "efgh", "ij"}
+	if len(segs) != len(want) {
+		t.Fatalf("expected %d segments, got %d", len(want), len(segs))
+	}
+	for i, w := range want {
+		if segs[i].Content != w {
+			t.Fatalf("segment %d: expected %q, got %q", i, w, segs[i].Content)
+		}
+	}
+}
+
+func TestFixedSizeChunker_KeepsRunesWhole(t *testing.T) {
+	c := &FixedSizeChunker{Size: 2}
+	segs := c.Split("ãéîõ")
+
+	if len(segs) != 2 || segs[0].Content != "ãé" || segs[1].Content != "îõ" {
+		t.Fatalf("expected rune-aligned windows, got %+v", segs)
+	}
+}
+
+func TestTokenChunker_Windows(t *testing.T) {
+	c := &TokenChunker{MaxTokens: 2}
+	segs := c.Split("one two three four five")
+
+	want := []string{"one two", " three four", " five"}
+	if len(segs) != len(want) {
+		t.Fatalf("expected %d segments, got %d: %+v", len(want), len(segs), segs)
+	}
+	for i, w := range want {
+		if segs[i].Content != w {
+			t.Fatalf("segment %d: expected %q, got %q", i, w, segs[i].Content)
+		}
+	}
+}
+
+func TestTokenChunker_Overlap(t *testing.T) {
+	c := &TokenChunker{MaxTokens: 4, Overlap: 2}
+	segs := c.Split("a b c d e f g")
+
+	want := []string{"a b c d", " c d e f", " e f g"}
+	if len(segs) != len(want) {
+		t.Fatalf("expected %d segments, got %d: %+v", len(want), len(segs), segs)
+	}
+	for i, w := range want {
+		if segs[i].Content != w {
+			t.Fatalf("segment %d: expected %q, got %q", i, w, segs[i].Content)
+		}
+	}
+}
+
+func TestTokenChunker_RespectsMaxTokens(t *testing.T) {
+	text := strings.Repeat("Tokenization boundaries matter for embeddings, e.g. 12345 items. ", 50)
+	c := &TokenChunker{MaxTokens: 32, Overlap: 8}
+
+	for i, seg := range c.Split(text) {
+		if n := CountTokens(seg.Content); n > 32 {
+			t.Fatalf("segment %d has %d tokens, want <= 32", i, n)
+		}
+	}
+}
+
+func TestNewChunker_OverlapMustFitWindow(t *testing.T) {
+	if _, err := NewChunker("token", ChunkerOptions{Size: 10, Overlap: 10}); err == nil {
+		t.Fatalf("expected error when overlap >= window")
+	}
+	if _, err := NewChunker("token", ChunkerOptions{Size: 10, Overlap: 3}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestParagraphChunker_MergesShortParagraphs(t *testing.T) {
+	text := "First paragraph.\n\nSecond paragraph.\n\n\nA third, much longer paragraph that does not fit."
+	c := &ParagraphChunker{MaxChars: 40}
+	segs := c.Split(text)
+
+	if len(segs) != 2 {
+		t.Fatalf("expected 2 segments, got %d: %+v", len(segs), segs)
+	}
+	if segs[0].Content != "First paragraph.\n\nSecond paragraph." {
+		t.Fatalf("unexpected first segment %q", segs[0].Content)
+	}
+}
+
+func TestChunkWith_UsesStrategy(t *testing.T) {
+	e := &fakeEmbedder{}
+	chunks, err := ChunkWith(context.Background(), &FixedSizeChunker{Size: 5}, "abcdefghij", "doc", e)
+	if err != nil {
+		t.Fatalf("chunking failed: %v", err)
+	}
+
+	if len(chunks) != 2 {
+		t.Fatalf("expected 2 chunks, got %d", len(chunks))
+	}
+	if chunks[1].ID != "doc-2" || chunks[1].Content != "fghij" {
+		t.Fatalf("unexpected second chunk %+v", chunks[1])
+	}
+}
+
+func TestChunkDocument_Metadata(t *testing.T) {
+	text := "  Page one text. More of page one. Page two starts here."
+	doc := Document{
+		ID:         "doc-42",
+		Source:     "report.pdf",
+		Text:       text,
+		Attributes: map[string]string{"customer": "acme"},
+		Pages:      []int{0, 35},
+	}
+	chunks, err := ChunkDocument(context.Background(), &SentenceChunker{MaxSentences: 1}, doc, &fakeEmbedder{})
+	if err != nil {
+		t.Fatalf("chunking failed: %v", err)
+	}
+
+	if len(chunks) != 3 {
+		t.Fatalf("expected 3 chunks, got %d", len(chunks))
+	}
+	for i, ch := range chunks {
+		if ch.Index != i || ch.DocumentID != "doc-42" || ch.Source != "report.pdf" {
+			t.Fatalf("chunk %d: unexpected identity %+v", i, ch)
+		}
+		if text[ch.Start:ch.End] != ch.Content {
+			t.Fatalf("chunk %d: offsets %d-%d do not match content %q", i, ch.Start, ch.End, ch.Content)
+		}
+		if ch.Attributes["customer"] != "acme" {
+			t.Fatalf("chunk %d: missing attributes, got %v", i, ch.Attributes)
+		}
+		if ch.CreatedAt.IsZero() || !ch.CreatedAt.Equal(chunks[0].CreatedAt) {
+			t.Fatalf("chunk %d: expected a shared creation time, got %v", i, ch.CreatedAt)
+		}
+	}
+	if chunks[0].Start != 2 {
+		t.Fatalf("expected leading whitespace to be skipped, got start %d", chunks[0].Start)
+	}
+	if chunks[1].Page != 1 || chunks[2].Page != 2 {
+		t.Fatalf("expected pages 1 and 2, got %d and %d", chunks[1].Page, chunks[2].Page)
+	}
+}
+
+func TestChunkDocument_NoPages(t *testing.T) {
+	chunks, err := ChunkWith(context.Background(), &ParagraphChunker{}, "Hello.\n\nWorld.", "notes", &fakeEmbedder{})
+	if err != nil {
+		t.Fatalf("chunking failed: %v", err)
+	}
+
+	if len(chunks) != 1 || chunks[0].Page != 0 || chunks[0].DocumentID != "notes" {
+		t.Fatalf("unexpected chunks %+v", chunks)
+	}
+}
+
+func TestChunkDocument_RecordsEmbedderModel(t *testing.T) {
+	chunks, err := ChunkDocument(context.Background(), &SentenceChunker{}, Document{ID: "d", Text: "One. Two."}, NewSimpleEmbedder())
+	if err != nil {
+		t.Fatalf("chunking failed: %v", err)
+	}
+	if len(chunks) == 0 || chunks[0].Model != "simple" {
+		t.Fatalf("expected model %q on chunks, got %+v", "simple", chunks)
+	}
+	if got := EmbedderModel(&fakeEmbedder{}); got != "" {
+		t.Fatalf("expected no model for an embedder that does not name one, got %q", got)
+	}
+}
+
+func TestChunkDocument_EmbeddingError(t *testing.T) {
+	doc := Document{ID: "d", Text: "One. Two. Three. Four."}
+	chunks, err := ChunkDocument(context.Background(), &SentenceChunker{MaxSentences: 1}, doc, &failingEmbedder{ok: 2})
+	if !errors.Is(err, ErrEmbedderUnavailable) {
+		t.Fatalf("expected the embedder error, got %v", err)
+	}
+	if chunks != nil {
+		t.Fatalf("expected no chunks on failure, got %d", len(chunks))
+	}
+}
@@ -0,0 +1,340 @@
+package rag
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"maps"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const defaultCodeChars = 1500
+
+// Metadata keys set by CodeChunker.
+const (
+	MetaLanguage = "language"
+	MetaSymbol   = "symbol"
+	MetaKind     = "kind"
+)
+
+// CodeChunker splits source code. Go files are parsed with go/parser and
+// every top-level declaration (func, method, type, const or var block)
+// becomes one segment, doc comment included; the package clause and the
+// imports form a segment of their own, with any build tags above them.
+// Nothing is dropped: a comment between declarations goes with the one
+// after it. A declaration longer than MaxChars is split further, at blank
+// lines when it has some and between lines otherwise, each part keeping
+// the declaration's metadata.
+//
+// Anything else, including Go that does not parse, falls back to a
+// language-agnostic heuristic: blocks end at a blank line outside any
+// braces when the next line is not indented, and small blocks are packed
+// together up to MaxChars.
+type CodeChunker struct {
+	MaxChars int
+}
+
+func (c *CodeChunker) Split(text string) []Segment {
+	maxChars := c.MaxChars
+	if maxChars <= 0 {
+		maxChars = defaultCodeChars
+	}
+	if segs, ok := splitGo(text, maxChars); ok {
+		return segs
+	}
+	return splitCodeBlocks(text, maxChars)
+}
+
+// splitGo returns one segment per top-level declaration, or false if text
+// is not a Go file. The segments cover the whole file but for whitespace.
+func splitGo(text string, maxChars int) ([]Segment, bool) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", text, parser.ParseComments)
+	if err != nil {
+		return nil, false
+	}
+	offset := func(p token.Pos) int { return fset.Position(p).Offset }
+
+	var segments []Segment
+	// Each segment starts where the previous one ended, skipping blank
+	// space, so floating comments join the declaration below them.
+	pos := 0
+	add := func(end int, kind, symbol string) {
+		start := len(text) - len(strings.TrimLeftFunc(text[pos:], unicode.IsSpace))
+		if start >= end {
+			return
+		}
+		meta := map[string]string{MetaLanguage: "go", MetaKind: kind}
+		if symbol != "" {
+			meta[MetaSymbol] = symbol
+		}
+		seg := segmentAt(text, start, end)
+		seg.StartLine = 1 + strings.Count(text[:start], "\n")
+		seg.EndLine = seg.StartLine + strings.Count(seg.Content, "\n")
+		seg.Metadata = meta
+		if end-start > maxChars {
+			segments = append(segments, splitLong(seg, maxChars)...)
+		} else {
+			segments = append(segments, seg)
+		}
+		pos = end
+	}
+
+	// Build tags, file doc comment, package clause and imports.
+	headerEnd := file.Name.End()
+	for _, decl := range file.Decls {
+		if d, ok := decl.(*ast.GenDecl); ok && d.Tok == token.IMPORT {
+			headerEnd = d.End()
+		}
+	}
+	add(offset(headerEnd), "package", file.Name.Name)
+
+	for _, decl := range file.Decls {
+		switch d := decl.(type) {
+		case *ast.GenDecl:
+			if d.Tok != token.IMPORT {
+				add(offset(d.End()), d.Tok.String(), genDeclNames(d))
+			}
+		case *ast.FuncDecl:
+			kind, symbol := "func", d.Name.Name
+			if d.Recv != nil && len(d.Recv.List) > 0 {
+				kind, symbol = "method", receiverType(d.Recv.List[0].Type)+"."+symbol
+			}
+			add(offset(d.End()), kind, symbol)
+		}
+	}
+	// Comments after the last declaration.
+	if end := len(strings.TrimRightFunc(text, unicode.IsSpace)); end > pos {
+		if len(segments) > 0 && segments[len(segments)-1].End == pos {
+			last := &segments[len(segments)-1]
+			*last = withEnd(text, *last, end)
+			if last.End-last.Start > maxChars {
+				segments = append(segments[:len(segments)-1], splitLong(*last, maxChars)...)
+			}
+		} else {
+			add(end, "comment", "")
+		}
+	}
+	return segments, true
+}
+
+// withEnd moves the end of seg to end.
+func withEnd(text string, seg Segment, end int) Segment {
+	moved := segmentAt(text, seg.Start, end)
+	moved.StartLine, moved.Metadata = seg.StartLine, seg.Metadata
+	moved.EndLine = seg.StartLine + strings.Count(moved.Content, "\n")
+	return moved
+}
+
+// splitLong splits a segment longer than maxChars: first like the fallback
+// heuristic (a comment block apart from its code, say), then between
+// lines, preferring blank ones. Only a single line longer than maxChars is
+// cut mid-line. The parts share seg's metadata.
+func splitLong(seg Segment, maxChars int) []Segment {
+	var out []Segment
+	for _, block := range splitCodeBlocks(seg.Content, maxChars) {
+		for _, part := range splitLines(block.Content, maxChars) {
+			part.Start += seg.Start + block.Start
+			part.End += seg.Start + block.Start
+			part.StartLine += seg.StartLine + block.StartLine - 2
+			part.EndLine += seg.StartLine + block.StartLine - 2
+			part.Metadata = maps.Clone(seg.Metadata)
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+// splitLines packs whole lines of text into segments of at most maxChars,
+// cutting at the last blank line of a full segment when there is one.
+func splitLines(text string, maxChars int) []Segment {
+	var out []Segment
+	emit := func(start, end int) {
+		content := strings.TrimRightFunc(text[start:end], unicode.IsSpace)
+		if strings.TrimSpace(content) == "" {
+			return
+		}
+		seg := segmentAt(text, start, start+len(content))
+		seg.StartLine = 1 + strings.Count(text[:start], "\n")
+		seg.EndLine = seg.StartLine + strings.Count(content, "\n")
+		out = append(out, seg)
+	}
+
+	start, blank := 0, -1 // blank: start of the last blank line in the piece
+	for pos := 0; pos < len(text); {
+		next := len(text)
+		if i := strings.IndexByte(text[pos:], '\n'); i >= 0 {
+			next = pos + i + 1
+		}
+		line := text[pos:next]
+		if next-start > maxChars && pos > start {
+			cut := pos
+			if blank > start {
+				cut = blank
+			}
+			emit(start, cut)
+			start, blank = cut, -1
+			continue
+		}
+		if len(line) > maxChars {
+			// A single huge line: cut it at rune boundaries.
+			for end := start; end < next; {
+				cut := min(end+maxChars, next)
+				for cut < next && !utf8.RuneStart(text[cut]) {
+					cut--
+				}
+				if cut == end {
+					// maxChars is narrower than this rune; keep it whole.
+					_, size := utf8.DecodeRuneInString(text[end:])
+					cut = end + size
+				}
+				emit(end, cut)
+				end = cut
+			}
+			start, pos = next, next
+			continue
+		}
+		if strings.TrimSpace(line) == "" && pos > start {
+			blank = pos
+		}
+		pos = next
+	}
+	emit(start, len(text))
+	// Leading blank lines of a piece are not content.
+	for i := range out {
+		content := out[i].Content
+		space := len(content) - len(strings.TrimLeftFunc(content, unicode.IsSpace))
+		skipped := strings.LastIndexByte(content[:space], '\n') + 1
+		out[i].StartLine += strings.Count(content[:skipped], "\n")
+		out[i].Start += skipped
+		out[i].Content = content[skipped:]
+	}
+	return out
+}
+
+// genDeclNames lists the names declared by a type, const or var block.
+func genDeclNames(d *ast.GenDecl) string {
+	var names []string
+	for _, spec := range d.Specs {
+		switch s := spec.(type) {
+		case *ast.TypeSpec:
+			names = append(names, s.Name.Name)
+		case *ast.ValueSpec:
+			for _, n := range s.Names {
+				names = append(names, n.Name)
+			}
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+func receiverType(expr ast.Expr) string {
+	switch t := expr.(type) {
+	case *ast.StarExpr:
+		return receiverType(t.X)
+	case *ast.IndexExpr: // generic receiver T[K]
+		return receiverType(t.X)
+	case *ast.IndexListExpr:
+		return receiverType(t.X)
+	case *ast.Ident:
+		return t.Name
+	}
+	return ""
+}
+
+// splitCodeBlocks is the fallback for languages we do not parse.
+func splitCodeBlocks(text string, maxChars int) []Segment {
+	lines := strings.SplitAfter(text, "\n")
+
+	type block struct{ start, end, startLine, endLine int }
+	var blocks []block
+	cur := block{start: -1}
+	depth, pos := 0, 0
+
+	for i, l := range lines {
+		trimmed := strings.TrimSpace(l)
+		if trimmed == "" {
+			if cur.start >= 0 && depth <= 0 && !nextLineIndented(lines[i+1:]) {
+				blocks = append(blocks, cur)
+				cur = block{start: -1}
+			}
+		} else {
+			if cur.start < 0 {
+				cur = block{start: pos, startLine: i + 1}
+			}
+			cur.end = pos + len(strings.TrimRight(l, "\r\n"))
+			cur.endLine = i + 1
+			depth += braceDelta(trimmed)
+		}
+		pos += len(l)
+	}
+	if cur.start >= 0 {
+		blocks = append(blocks, cur)
+	}
+
+	var segments []Segment
+	var pack block
+	packing := false
+	flush := func() {
+		if packing {
+			seg := segmentAt(text, pack.start, pack.end)
+			seg.StartLine, seg.EndLine = pack.startLine, pack.endLine
+			segments = append(segments, seg)
+			packing = false
+		}
+	}
+	for _, b := range blocks {
+		if packing && b.end-pack.start > maxChars {
+			flush()
+		}
+		if !packing {
+			pack, packing = b, true
+			continue
+		}
+		pack.end, pack.endLine = b.end, b.endLine
+	}
+	flush()
+
+	return segments
+}
+
+// nextLineIndented reports whether the next non-blank line starts with
+// whitespace, i.e. the current block (a Python body, say) goes on.
+func nextLineIndented(lines []string) bool {
+	for _, l := range lines {
+		if strings.TrimSpace(l) == "" {
+			continue
+		}
+		return l[0] == ' ' || l[0] == '\t'
+	}
+	return false
+}
+
+// braceDelta counts opening minus closing brackets of any kind on a line,
+// ignoring those inside string literals and after a line comment.
+func braceDelta(line string) int {
+	delta := 0
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		ch := line[i]
+		switch {
+		case quote != 0:
+			if ch == '\\' {
+				i++
+			} else if ch == quote {
+				quote = 0
+			}
+		case ch == '"' || ch == '\'' || ch == '`':
+			quote = ch
+		case ch == '/' && i+1 < len(line) && line[i+1] == '/', ch == '#':
+			return delta
+		case ch == '{' || ch == '(' || ch == '[':
+			delta++
+		case ch == '}' || ch == ')' || ch == ']':
+			delta--
+		}
+	}
+	return delta
+}
@@ -0,0 +1,180 @@
+package rag
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+const sampleGo = `// Package demo is a sample.
+package demo
+
+import (
+	"fmt"
+)
+
+// Greeting is what we say.
+const Greeting = "hi"
+
+// Server serves things.
+type Server struct{}
+
+// Hello says hello.
+func (s *Server) Hello() {
+	fmt.Println(Greeting)
+}
+
+func helper() int {
+	return 1
+}
+`
+
+func TestCodeChunker_GoDeclarations(t *testing.T) {
+	c := &CodeChunker{}
+	segs := c.Split(sampleGo)
+
+	want := []struct {
+		kind, symbol string
+		startLine    int
+		endLine      int
+	}{
+		{"package", "demo", 1, 6},
+		{"const", "Greeting", 8, 9},
+		{"type", "Server", 11, 12},
+		{"method", "Server.Hello", 14, 17},
+		{"func", "helper", 19, 21},
+	}
+	if len(segs) != len(want) {
+		t.Fatalf("expected %d segments, got %d: %+v", len(want), len(segs), segs)
+	}
+	for i, w := range want {
+		s := segs[i]
+		if s.Metadata[MetaKind] != w.kind || s.Metadata[MetaSymbol] != w.symbol {
+			t.Fatalf("segment %d: expected %s %s, got %v", i, w.kind, w.symbol, s.Metadata)
+		}
+		if s.StartLine != w.startLine || s.EndLine != w.endLine {
+			t.Fatalf("segment %d: expected lines %d-%d, got %d-%d", i, w.startLine, w.endLine, s.StartLine, s.EndLine)
+		}
+		if s.Metadata[MetaLanguage] != "go" {
+			t.Fatalf("segment %d: expected language go, got %q", i, s.Metadata[MetaLanguage])
+		}
+	}
+	if !strings.HasPrefix(segs[3].Content, "// Hello says hello.") {
+		t.Fatalf("doc comment not attached: %q", segs[3].Content)
+	}
+}
+
+func TestCodeChunker_FallbackHeuristics(t *testing.T) {
+	src := "import os\n\n\ndef a():\n    x = 1\n\n    return x\n\n\nfunction b() {\n  if (x) {\n\n    y()\n  }\n}\n"
+	c := &CodeChunker{MaxChars: 10}
+	segs := c.Split(src)
+
+	if len(segs) != 3 {
+		t.Fatalf("expected 3 segments, got %d: %+v", len(segs), segs)
+	}
+	if segs[1].Content != "def a():\n    x = 1\n\n    return x" {
+		t.Fatalf("python body was split: %q", segs[1].Content)
+	}
+	if segs[2].StartLine != 10 || segs[2].EndLine != 15 {
+		t.Fatalf("expected lines 10-15 for the JS function, got %d-%d", segs[2].StartLine, segs[2].EndLine)
+	}
+}
+
+func TestCodeChunker_PacksSmallBlocks(t *testing.T) {
+	src := "a = 1\n\nb = 2\n\nc = 3\n"
+	c := &CodeChunker{MaxChars: 100}
+	segs := c.Split(src)
+
+	if len(segs) != 1 || segs[0].StartLine != 1 || segs[0].EndLine != 5 {
+		t.Fatalf("expected a single packed segment spanning lines 1-5, got %+v", segs)
+	}
+}
+
+func TestCodeChunker_KeepsTagsAndFloatingComments(t *testing.T) {
+	src := `//go:build linux
+
+package demo
+
+func a() {}
+
+// TODO: remove once b is gone.
+
+func b() {}
+
+// trailing note
+`
+	segs := (&CodeChunker{}).Split(src)
+	if len(segs) != 3 {
+		t.Fatalf("expected 3 segments, got %d: %+v", len(segs), segs)
+	}
+	if !strings.HasPrefix(segs[0].Content, "//go:build linux") || segs[0].StartLine != 1 {
+		t.Fatalf("build tag dropped: %+v", segs[0])
+	}
+	if !strings.HasPrefix(segs[2].Content, "// TODO") || segs[2].Metadata[MetaSymbol] != "b" {
+		t.Fatalf("floating comment not kept with b: %+v", segs[2])
+	}
+	if !strings.HasSuffix(segs[2].Content, "// trailing note") || segs[2].EndLine != 11 {
+		t.Fatalf("trailing comment dropped: %+v", segs[2])
+	}
+}
+
+func TestCodeChunker_SplitsLongDeclarations(t *testing.T) {
+	var body strings.Builder
+	for i := range 40 {
+		fmt.Fprintf(&body, "\tx%d := compute(%d)\n", i, i)
+		if i%10 == 9 {
+			body.WriteString("\n")
+		}
+	}
+	src := "package demo\n\n// Long does a lot.\nfunc Long() {\n" + body.String() + "}\n"
+	segs := (&CodeChunker{MaxChars: 200}).Split(src)
+
+	if len(segs) < 3 {
+		t.Fatalf("expected the function split, got %d segments", len(segs))
+	}
+	for i, s := range segs[1:] {
+		if len(s.Content) > 200 {
+			t.Fatalf("part %d is %d chars, over MaxChars", i, len(s.Content))
+		}
+		if s.Metadata[MetaSymbol] != "Long" || s.Metadata[MetaKind] != "func" {
+			t.Fatalf("part %d lost its metadata: %v", i, s.Metadata)
+		}
+		if s.Content != src[s.Start:s.End] {
+			t.Fatalf("part %d offsets do not match its content", i)
+		}
+		if first := strings.Split(src, "\n")[s.StartLine-1]; !strings.HasPrefix(s.Content, first) {
+			t.Fatalf("part %d: line %d is %q, content starts %q", i, s.StartLine, first, s.Content)
+		}
+	}
+	// Every line of the function is in some part.
+	var joined strings.Builder
+	for _, s := range segs[1:] {
+		joined.WriteString(s.Content)
+	}
+	if strings.Join(strings.Fields(joined.String()), " ") != strings.Join(strings.Fields(src[len("package demo\n\n"):]), " ") {
+		t.Fatal("parts do not add up to the function")
+	}
+	if !strings.HasPrefix(segs[1].Content, "// Long does a lot.") {
+		t.Fatalf("expected the doc comment first, got %q", segs[1].Content)
+	}
+}
+
+func TestCodeChunker_MaxCharsNarrowerThanARune(t *testing.T) {
+	src := "package demo\n\nvar s = \"café 日本語\"\n"
+	for _, maxChars := range []int{1, 2} {
+		done := make(chan []Segment, 1)
+		go func() { done <- (&CodeChunker{MaxChars: maxChars}).Split(src) }()
+		select {
+		case segs := <-done:
+			for _, s := range segs {
+				if s.Content != src[s.Start:s.End] || !utf8.ValidString(s.Content) {
+					t.Fatalf("MaxChars %d: bad segment %q at %d-%d", maxChars, s.Content, s.Start, s.End)
+				}
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("MaxChars %d: Split did not return", maxChars)
+		}
+	}
+}
@@ -0,0 +1,424 @@
+package rag
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultCollection always exists and receives uploads that do not name a
+// collection.
+const DefaultCollection = "default"
+
+const collectionMetaFile = "collection.json"
+
+var (
+	ErrCollectionExists   = errors.New("collection already exists")
+	ErrCollectionNotFound = errors.New("collection not found")
+	// ErrModelMismatch and ErrDimensionMismatch reject vectors that cannot
+	// be compared with those already in a collection.
+	ErrModelMismatch     = errors.New("embedding model does not match collection")
+	ErrDimensionMismatch = errors.New("embedding dimension does not match collection")
+)
+
+var collectionNameRE = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+// ValidateCollectionName checks a collection name: lower-case letters,
+// digits, "-" and "_", starting with a letter or digit, at most 63 bytes.
+// Names double as directory names in file-backed collections.
+func ValidateCollectionName(name string) error {
+	if !collectionNameRE.MatchString(name) {
+		return fmt.Errorf("invalid collection name %q (want [a-z0-9][a-z0-9_-]*, at most 63 bytes)", name)
+	}
+	return nil
+}
+
+// CollectionInfo summarizes one collection.
+type CollectionInfo struct {
+	Name          string
+	Model         string `json:",omitempty"`
+	Dimension     int    `json:",omitempty"`
+	DocumentCount int
+	ChunkCount    int
+	CreatedAt     time.Time
+}
+
+// collectionMeta is what a collection remembers about itself.
+type collectionMeta struct {
+	Name      string `json:"name"`
+	Model     string `json:"model,omitempty"`
+	Dimension int    `json:"dimension,omitempty"`
+	// ModelFixed is set when Model was given at creation rather than
+	// learned from the chunks added.
+	ModelFixed bool      `json:"model_fixed,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// unpinned returns meta without the model and dimension learned from
+// chunks.
+func (meta collectionMeta) unpinned() collectionMeta {
+	meta.Dimension = 0
+	if !meta.ModelFixed {
+		meta.Model = ""
+	}
+	return meta
+}
+
+// Collection is a named VectorStore holding one corpus. It remembers the
+// embedder model and the dimension of its vectors, taken from the first
+// chunks added unless set when it was created, and rejects chunks that do
+// not match: vectors from different models are not comparable. What it
+// learned from chunks is forgotten once it is empty again.
+type Collection struct {
+	VectorStore
+
+	name    string
+	mu      sync.Mutex // guards meta and deleted, serializes checked writes
+	meta    collectionMeta
+	deleted bool
+	save    func(collectionMeta) error // persists meta; nil in memory
+}
+
+// Name returns the collection name.
+func (c *Collection) Name() string { return c.name }
+
+// Info summarizes the collection.
+func (c *Collection) Info() CollectionInfo {
+	c.mu.Lock()
+	meta := c.meta
+	c.mu.Unlock()
+	return CollectionInfo{
+		Name:          meta.Name,
+		Model:         meta.Model,
+		Dimension:     meta.Dimension,
+		DocumentCount: len(c.Documents()),
+		ChunkCount:    c.Count(),
+		CreatedAt:     meta.CreatedAt,
+	}
+}
+
+// CheckQuery reports whether a query embedding from the given model can be
+// compared with the collection's vectors. An empty model is not checked.
+func (c *Collection) CheckQuery(embedding []float64, model string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.check(c.meta, model, len(embedding))
+}
+
+func (c *Collection) check(meta collectionMeta, model string, dim int) error {
+	if meta.Model != "" && model != "" && model != meta.Model {
+		return fmt.Errorf("%w: %q uses %q, got %q", ErrModelMismatch, meta.Name, meta.Model, model)
+	}
+	if meta.Dimension != 0 && dim != 0 && dim != meta.Dimension {
+		return fmt.Errorf("%w: %q has %d dimensions, got %d", ErrDimensionMismatch, meta.Name, meta.Dimension, dim)
+	}
+	return nil
+}
+
+// admit checks chunks against the collection and pins its model and
+// dimension if they are still unknown, or were learned from chunks that
+// are gone. The caller holds c.mu.
+func (c *Collection) admit(chunks []Chunk) error {
+	if c.deleted {
+		return fmt.Errorf("%w: %q", ErrCollectionNotFound, c.name)
+	}
+	meta := c.meta
+	if len(chunks) > 0 && c.VectorStore.Count() == 0 {
+		meta = meta.unpinned()
+	}
+	for _, ch := range chunks {
+		if err := c.check(meta, ch.Model, len(ch.Embedding)); err != nil {
+			return err
+		}
+		if meta.Model == "" {
+			meta.Model = ch.Model
+		}
+		if meta.Dimension == 0 {
+			meta.Dimension = len(ch.Embedding)
+		}
+	}
+	return c.setMeta(meta)
+}
+
+// setMeta persists and applies meta if it changed. The caller holds c.mu.
+func (c *Collection) setMeta(meta collectionMeta) error {
+	if meta == c.meta {
+		return nil
+	}
+	if c.save != nil {
+		if err := c.save(meta); err != nil {
+			return err
+		}
+	}
+	c.meta = meta
+	return nil
+}
+
+var (
+	_ VectorStore = (*Collection)(nil)
+	_ Counter     = (*Collection)(nil)
+)
+
+func (c *Collection) Add(chunks ...Chunk) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.admit(chunks); err != nil {
+		return err
+	}
+	return c.VectorStore.Add(chunks...)
+}
+
+func (c *Collection) AddDocument(docID string, policy ConflictPolicy, chunks ...Chunk) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.admit(chunks); err != nil {
+		return 0, err
+	}
+	return c.VectorStore.AddDocument(docID, policy, chunks...)
+}
+
+// CountMatches counts through the underlying store when it is a Counter.
+func (c *Collection) CountMatches(q CountQuery) (int, bool) {
+	if counter, ok := c.VectorStore.(Counter); ok {
+		return counter.CountMatches(q)
+	}
+	return 0, false
+}
+
+// Clear empties the collection and forgets the model and dimension learned
+// from its chunks, so the next upload may come from another embedder.
+func (c *Collection) Clear() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.deleted {
+		return fmt.Errorf("%w: %q", ErrCollectionNotFound, c.name)
+	}
+	if err := c.VectorStore.Clear(); err != nil {
+		return err
+	}
+	return c.setMeta(c.meta.unpinned())
+}
+
+// Collections manages named collections. The default collection always
+// exists and cannot be deleted.
+type Collections struct {
+	mu     sync.RWMutex
+	byName map[string]*Collection
+
+	open   func(meta collectionMeta) (*Collection, error)
+	remove func(name string) error
+}
+
+// NewCollections keeps collections in memory, each in a store returned by
+// newStore.
+func NewCollections(newStore func() VectorStore) *Collections {
+	c := &Collections{
+		byName: map[string]*Collection{},
+		open: func(meta collectionMeta) (*Collection, error) {
+			return &Collection{VectorStore: newStore(), name: meta.Name, meta: meta}, nil
+		},
+		remove: func(string) error { return nil },
+	}
+	c.Create(DefaultCollection, "")
+	return c
+}
+
+// OpenCollections keeps every collection in a FileStore under dir and
+// reopens the ones already there. The default collection lives in dir
+// itself, so a data directory from before collections existed becomes the
+// default collection; the others live in dir/collections/<name>.
+func OpenCollections(dir string, opts FileStoreOptions) (*Collections, error) {
+	pathOf := func(name string) string {
+		if name == DefaultCollection {
+			return dir
+		}
+		return filepath.Join(dir, "collections", name)
+	}
+	c := &Collections{byName: map[string]*Collection{}}
+	c.open = func(meta collectionMeta) (*Collection, error) {
+		path := pathOf(meta.Name)
+		if err := os.MkdirAll(path, 0o755); err != nil {
+			return nil, fmt.Errorf("create collection dir: %w", err)
+		}
+		metaPath := filepath.Join(path, collectionMetaFile)
+		if data, err := os.ReadFile(metaPath); err == nil {
+			if err := json.Unmarshal(data, &meta); err != nil {
+				return nil, fmt.Errorf("read %s: %w", metaPath, err)
+			}
+		}
+		save := func(m collectionMeta) error { return writeFileAtomic(metaPath, m) }
+		if err := save(meta); err != nil {
+			return nil, err
+		}
+		store, err := OpenFileStore(path, opts)
+		if err != nil {
+			return nil, err
+		}
+		return &Collection{VectorStore: store, name: meta.Name, meta: meta, save: save}, nil
+	}
+	c.remove = func(name string) error {
+		return os.RemoveAll(pathOf(name))
+	}
+
+	names := []string{DefaultCollection}
+	entries, err := os.ReadDir(filepath.Join(dir, "collections"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("read collections: %w", err)
+	}
+	for _, e := range entries {
+		if e.IsDir() && ValidateCollectionName(e.Name()) == nil && e.Name() != DefaultCollection {
+			names = append(names, e.Name())
+		}
+	}
+	for _, name := range names {
+		if _, err := c.Create(name, ""); err != nil {
+			c.Close()
+			return nil, fmt.Errorf("open collection %q: %w", name, err)
+		}
+	}
+	return c, nil
+}
+
+// Create adds an empty collection. model may be empty, in which case the
+// first chunks added decide it.
+func (c *Collections) Create(name, model string) (*Collection, error) {
+	if err := ValidateCollectionName(name); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.byName[name]; ok {
+		return nil, fmt.Errorf("%w: %q", ErrCollectionExists, name)
+	}
+	coll, err := c.open(collectionMeta{
+		Name: name, Model: model, ModelFixed: model != "", CreatedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if coll.meta.Dimension == 0 {
+		// Reopened stores know their dimension from the chunks they hold.
+		if chunks := coll.List(); len(chunks) > 0 {
+			if err := coll.admit(chunks[:1]); err != nil {
+				if closer, ok := coll.VectorStore.(io.Closer); ok {
+					closer.Close()
+				}
+				return nil, err
+			}
+		}
+	}
+	c.byName[name] = coll
+	return coll, nil
+}
+
+// Get returns a collection by name.
+func (c *Collections) Get(name string) (*Collection, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	coll, ok := c.byName[name]
+	return coll, ok
+}
+
+// List summarizes every collection, by name.
+func (c *Collections) List() []CollectionInfo {
+	c.mu.RLock()
+	colls := make([]*Collection, 0, len(c.byName))
+	for _, coll := range c.byName {
+		colls = append(colls, coll)
+	}
+	c.mu.RUnlock()
+
+	infos := make([]CollectionInfo, len(colls))
+	for i, coll := range colls {
+		infos[i] = coll.Info()
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
+
+// Delete drops a collection and its data. Writes through a *Collection
+// obtained before then fail with ErrCollectionNotFound, or ErrStoreClosed
+// from a FileStore. If its store cannot be closed or its data removed, the
+// collection is reopened and kept, so it does not only come back after a
+// restart.
+func (c *Collections) Delete(name string) error {
+	if name == DefaultCollection {
+		return errors.New("the default collection cannot be deleted")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	coll, ok := c.byName[name]
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrCollectionNotFound, name)
+	}
+	coll.mu.Lock()
+	coll.deleted = true
+	meta := coll.meta
+	coll.mu.Unlock()
+	var err error
+	if closer, ok := coll.VectorStore.(io.Closer); ok {
+		err = closer.Close()
+	}
+	if err == nil {
+		err = c.remove(name)
+	}
+	if err != nil {
+		reopened, openErr := c.open(meta)
+		if openErr != nil {
+			delete(c.byName, name)
+			return errors.Join(err, openErr)
+		}
+		c.byName[name] = reopened
+		return err
+	}
+	delete(c.byName, name)
+	return nil
+}
+
+// Close releases the stores of every collection.
+func (c *Collections) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var errs []error
+	for _, coll := range c.byName {
+		if closer, ok := coll.VectorStore.(io.Closer); ok {
+			errs = append(errs, closer.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// writeFileAtomic writes v as JSON to path through a temp file and rename.
+func writeFileAtomic(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("create %s: %w", path, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("sync %s: %w", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close %s: %w", path, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("rename %s: %w", path, err)
+	}
+	return syncDir(filepath.Dir(path))
+}
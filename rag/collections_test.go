@@ -0,0 +1,222 @@
+package rag
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newMemCollections() *Collections {
+	return NewCollections(func() VectorStore { return NewInMemoryStore() })
+}
+
+func TestCollections_CreateListDelete(t *testing.T) {
+	cols := newMemCollections()
+	if _, ok := cols.Get(DefaultCollection); !ok {
+		t.Fatalf("expected the default collection to exist")
+	}
+	if _, err := cols.Create("contracts", "model-a"); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if _, err := cols.Create("contracts", ""); !errors.Is(err, ErrCollectionExists) {
+		t.Fatalf("expected ErrCollectionExists, got %v", err)
+	}
+	if _, err := cols.Create("Bad Name", ""); err == nil {
+		t.Fatalf("expected an invalid name to be rejected")
+	}
+
+	infos := cols.List()
+	if len(infos) != 2 || infos[0].Name != "contracts" || infos[1].Name != DefaultCollection {
+		t.Fatalf("expected [contracts default], got %+v", infos)
+	}
+	if infos[0].Model != "model-a" || infos[0].CreatedAt.IsZero() {
+		t.Fatalf("unexpected info %+v", infos[0])
+	}
+
+	if err := cols.Delete("contracts"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if err := cols.Delete("contracts"); !errors.Is(err, ErrCollectionNotFound) {
+		t.Fatalf("expected ErrCollectionNotFound, got %v", err)
+	}
+	if err := cols.Delete(DefaultCollection); err == nil {
+		t.Fatalf("expected the default collection to be protected")
+	}
+}
+
+func TestCollections_DeleteStopsWrites(t *testing.T) {
+	dir := t.TempDir()
+	cols, err := OpenCollections(dir, FileStoreOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cols.Close()
+	hr, _ := cols.Create("hr", "")
+	hr.Add(Chunk{ID: "1", DocumentID: "d", Embedding: []float64{1, 0}})
+
+	// A handler that looked the collection up before it was deleted.
+	if err := cols.Delete("hr"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if err := hr.Add(Chunk{ID: "2", Embedding: []float64{1, 0}}); !errors.Is(err, ErrCollectionNotFound) {
+		t.Fatalf("Add: expected ErrCollectionNotFound, got %v", err)
+	}
+	if err := hr.Clear(); !errors.Is(err, ErrCollectionNotFound) {
+		t.Fatalf("Clear: expected ErrCollectionNotFound, got %v", err)
+	}
+	if _, err := hr.DeleteDocument("d"); !errors.Is(err, ErrStoreClosed) {
+		t.Fatalf("DeleteDocument: expected ErrStoreClosed, got %v", err)
+	}
+}
+
+func TestCollections_DeleteKeepsCollectionWhenRemoveFails(t *testing.T) {
+	cols := newMemCollections()
+	hr, _ := cols.Create("hr", "m1")
+	cols.remove = func(string) error { return errors.New("disk says no") }
+
+	if err := cols.Delete("hr"); err == nil {
+		t.Fatal("expected the remove error")
+	}
+	again, ok := cols.Get("hr")
+	if !ok {
+		t.Fatal("expected the collection to stay listed")
+	}
+	if again.Info().Model != "m1" {
+		t.Fatalf("expected the reopened collection to keep its model, got %+v", again.Info())
+	}
+	if err := again.Add(Chunk{ID: "1", Model: "m1", Embedding: []float64{1}}); err != nil {
+		t.Fatalf("expected the reopened collection to take writes, got %v", err)
+	}
+	if err := hr.Add(Chunk{ID: "2", Model: "m1", Embedding: []float64{1}}); !errors.Is(err, ErrCollectionNotFound) {
+		t.Fatalf("expected the old handle to refuse writes, got %v", err)
+	}
+}
+
+func TestCollections_AreIsolated(t *testing.T) {
+	cols := newMemCollections()
+	a, _ := cols.Create("a", "")
+	b, _ := cols.Create("b", "")
+	a.Add(Chunk{ID: "1", DocumentID: "d", Embedding: []float64{1, 0}})
+	b.Add(Chunk{ID: "2", DocumentID: "d", Embedding: []float64{1, 0}})
+
+	a.Clear()
+	if a.Count() != 0 || b.Count() != 1 {
+		t.Fatalf("clearing a touched b: a=%d b=%d", a.Count(), b.Count())
+	}
+}
+
+func TestCollection_PinsModelAndDimension(t *testing.T) {
+	cols := newMemCollections()
+	coll, _ := cols.Create("docs", "")
+
+	if err := coll.Add(Chunk{ID: "1", Model: "m1", Embedding: []float64{1, 0, 0}}); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	info := coll.Info()
+	if info.Model != "m1" || info.Dimension != 3 || info.ChunkCount != 1 {
+		t.Fatalf("expected model m1 and 3 dimensions, got %+v", info)
+	}
+
+	if err := coll.Add(Chunk{ID: "2", Model: "m2", Embedding: []float64{1, 0, 0}}); !errors.Is(err, ErrModelMismatch) {
+		t.Fatalf("expected ErrModelMismatch, got %v", err)
+	}
+	if _, err := coll.AddDocument("d", ConflictReject, Chunk{ID: "3", Model: "m1", Embedding: []float64{1, 0}}); !errors.Is(err, ErrDimensionMismatch) {
+		t.Fatalf("expected ErrDimensionMismatch, got %v", err)
+	}
+	if coll.Count() != 1 {
+		t.Fatalf("rejected chunks were stored: %d", coll.Count())
+	}
+
+	if err := coll.CheckQuery([]float64{1, 0}, "m1"); !errors.Is(err, ErrDimensionMismatch) {
+		t.Fatalf("expected query dimension check, got %v", err)
+	}
+	if err := coll.CheckQuery([]float64{1, 0, 0}, ""); err != nil {
+		t.Fatalf("unexpected error for a matching query: %v", err)
+	}
+}
+
+func TestCollection_ClearForgetsLearnedModel(t *testing.T) {
+	cols := newMemCollections()
+	learned, _ := cols.Create("learned", "")
+	fixed, _ := cols.Create("fixed", "m1")
+	for _, coll := range []*Collection{learned, fixed} {
+		if err := coll.Add(Chunk{ID: "1", Model: "m1", Embedding: []float64{1, 0, 0}}); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+		if err := coll.Clear(); err != nil {
+			t.Fatalf("Clear: %v", err)
+		}
+	}
+
+	if info := learned.Info(); info.Model != "" || info.Dimension != 0 {
+		t.Fatalf("expected the learned model and dimension to be forgotten, got %+v", info)
+	}
+	if err := learned.Add(Chunk{ID: "2", Model: "m2", Embedding: []float64{1, 0}}); err != nil {
+		t.Fatalf("expected another embedder to be accepted after Clear, got %v", err)
+	}
+	if info := learned.Info(); info.Model != "m2" || info.Dimension != 2 {
+		t.Fatalf("expected model m2 and 2 dimensions, got %+v", info)
+	}
+
+	if info := fixed.Info(); info.Model != "m1" || info.Dimension != 0 {
+		t.Fatalf("expected the model set at creation to stay, got %+v", info)
+	}
+	if err := fixed.Add(Chunk{ID: "2", Model: "m2", Embedding: []float64{1, 0}}); !errors.Is(err, ErrModelMismatch) {
+		t.Fatalf("expected ErrModelMismatch, got %v", err)
+	}
+
+	// Emptying a collection by deleting its documents works the same way.
+	learned.Delete("2")
+	if err := learned.Add(Chunk{ID: "3", Model: "m3", Embedding: []float64{1}}); err != nil {
+		t.Fatalf("expected an empty collection to accept another embedder, got %v", err)
+	}
+}
+
+func TestOpenCollections_Persist(t *testing.T) {
+	dir := t.TempDir()
+
+	// A data directory from before collections becomes the default one.
+	legacy, err := OpenFileStore(dir, FileStoreOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy.Add(Chunk{ID: "old", DocumentID: "d", Embedding: []float64{1, 0}})
+	legacy.Close()
+
+	cols, err := OpenCollections(dir, FileStoreOptions{})
+	if err != nil {
+		t.Fatalf("OpenCollections: %v", err)
+	}
+	def, _ := cols.Get(DefaultCollection)
+	if def.Count() != 1 || def.Info().Dimension != 2 {
+		t.Fatalf("expected the legacy chunk in the default collection, got %+v", def.Info())
+	}
+	notes, err := cols.Create("notes", "m1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	notes.Add(Chunk{ID: "n", DocumentID: "n", Model: "m1", Embedding: []float64{0, 1, 0}})
+	gone, _ := cols.Create("gone", "")
+	gone.Add(Chunk{ID: "g", DocumentID: "g", Embedding: []float64{1}})
+	if err := cols.Delete("gone"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "collections", "gone")); !os.IsNotExist(err) {
+		t.Fatalf("expected the deleted collection's directory to be removed, got %v", err)
+	}
+	cols.Close()
+
+	cols, err = OpenCollections(dir, FileStoreOptions{})
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer cols.Close()
+	infos := cols.List()
+	if len(infos) != 2 || infos[0].Name != DefaultCollection || infos[1].Name != "notes" {
+		t.Fatalf("expected [default notes] after reopen, got %+v", infos)
+	}
+	if n := infos[1]; n.Model != "m1" || n.Dimension != 3 || n.ChunkCount != 1 {
+		t.Fatalf("notes lost its settings: %+v", n)
+	}
+}
@@ -0,0 +1,110 @@
+package rag
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Document is one upload, ready to be chunked.
+type Document struct {
+	ID         string
+	Source     string // filename, defaults to ID
+	Text       string
+	Attributes map[string]string // copied to every chunk
+
+	// Pages holds the byte offset in Text where each page starts, for
+	// documents that have pages (PDFs). Pages[0] is page 1.
+	Pages []int
+}
+
+// DocumentInfo summarizes one stored document.
+type DocumentInfo struct {
+	ID         string
+	Source     string
+	ChunkCount int
+	// Bytes is the size of the indexed text, up to the end of the last chunk.
+	Bytes      int
+	Model      string            `json:",omitempty"` // embedder model
+	Attributes map[string]string `json:",omitempty"`
+	CreatedAt  time.Time         // upload time
+}
+
+// summarizeDocuments groups chunks by document, in order of first appearance.
+func summarizeDocuments(chunks []Chunk) []DocumentInfo {
+	docs := []DocumentInfo{}
+	index := map[string]int{}
+	for _, ch := range chunks {
+		i, ok := index[ch.DocumentID]
+		if !ok {
+			i = len(docs)
+			index[ch.DocumentID] = i
+			docs = append(docs, DocumentInfo{
+				ID:         ch.DocumentID,
+				Source:     ch.Source,
+				Model:      ch.Model,
+				Attributes: ch.Attributes,
+				CreatedAt:  ch.CreatedAt,
+			})
+		}
+		d := &docs[i]
+		d.ChunkCount++
+		d.Bytes = max(d.Bytes, ch.End)
+	}
+	return docs
+}
+
+const maxDocumentIDLen = 200
+
+// NewDocumentID returns a random document ID for uploads that do not name
+// themselves, e.g. "doc-3f9a1c0b7d2e".
+func NewDocumentID() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return "doc-" + hex.EncodeToString(b)
+}
+
+// ValidateDocumentID checks a caller-supplied document ID. Chunk IDs are
+// "<document ID>-<n>", so unique document IDs give unique chunk IDs.
+func ValidateDocumentID(id string) error {
+	switch {
+	case id == "":
+		return errors.New("document id is empty")
+	case len(id) > maxDocumentIDLen:
+		return fmt.Errorf("document id longer than %d bytes", maxDocumentIDLen)
+	case strings.TrimSpace(id) != id:
+		return fmt.Errorf("document id %q has leading or trailing whitespace", id)
+	case strings.IndexFunc(id, unicode.IsControl) >= 0:
+		return fmt.Errorf("document id %q contains control characters", id)
+	}
+	return nil
+}
+
+// ErrDocumentExists is returned when adding a document whose ID is taken
+// under the ConflictReject policy.
+var ErrDocumentExists = errors.New("document already exists")
+
+// ConflictPolicy says what to do when a document ID is uploaded twice.
+type ConflictPolicy string
+
+const (
+	// ConflictReject refuses the new upload with ErrDocumentExists.
+	ConflictReject ConflictPolicy = "reject"
+	// ConflictReplace swaps the stored chunks for the new ones.
+	ConflictReplace ConflictPolicy = "replace"
+)
+
+// ParseConflictPolicy parses a policy name; "" means ConflictReject.
+func ParseConflictPolicy(s string) (ConflictPolicy, error) {
+	switch p := ConflictPolicy(s); p {
+	case "":
+		return ConflictReject, nil
+	case ConflictReject, ConflictReplace:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown conflict policy %q (available: %s, %s)", s, ConflictReject, ConflictReplace)
+}
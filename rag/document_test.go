@@ -0,0 +1,71 @@
+package rag
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestNewDocumentID_Unique(t *testing.T) {
+	seen := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		id := NewDocumentID()
+		if err := ValidateDocumentID(id); err != nil {
+			t.Fatalf("generated invalid id %q: %v", id, err)
+		}
+		if seen[id] {
+			t.Fatalf("duplicate id %q", id)
+		}
+		seen[id] = true
+	}
+}
+
+func TestValidateDocumentID(t *testing.T) {
+	valid := []string{"handbook", "My Report.pdf", "team/2025-01"}
+	for _, id := range valid {
+		if err := ValidateDocumentID(id); err != nil {
+			t.Fatalf("expected %q to be valid, got %v", id, err)
+		}
+	}
+	invalid := []string{"", " padded", "line\nbreak", strings.Repeat("x", maxDocumentIDLen+1)}
+	for _, id := range invalid {
+		if err := ValidateDocumentID(id); err == nil {
+			t.Fatalf("expected %q to be invalid", id)
+		}
+	}
+}
+
+func TestParseConflictPolicy(t *testing.T) {
+	if p, err := ParseConflictPolicy(""); err != nil || p != ConflictReject {
+		t.Fatalf("expected default reject, got %q, %v", p, err)
+	}
+	if p, err := ParseConflictPolicy("replace"); err != nil || p != ConflictReplace {
+		t.Fatalf("expected replace, got %q, %v", p, err)
+	}
+	if _, err := ParseConflictPolicy("merge"); err == nil {
+		t.Fatalf("expected error for unknown policy")
+	}
+}
+
+func TestInMemoryStore_AddDocumentConflicts(t *testing.T) {
+	store := NewInMemoryStore()
+	v1 := []Chunk{{ID: "a-1", DocumentID: "a"}, {ID: "a-2", DocumentID: "a"}}
+	v2 := []Chunk{{ID: "a-1", DocumentID: "a", Content: "new"}}
+
+	if _, err := store.AddDocument("a", ConflictReject, v1...); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := store.AddDocument("a", ConflictReject, v2...); !errors.Is(err, ErrDocumentExists) {
+		t.Fatalf("expected ErrDocumentExists, got %v", err)
+	}
+	replaced, err := store.AddDocument("a", ConflictReplace, v2...)
+	if err != nil || replaced != 2 {
+		t.Fatalf("expected 2 replaced chunks, got %d, %v", replaced, err)
+	}
+	if !store.HasDocument("a") || store.HasDocument("b") {
+		t.Fatalf("HasDocument reports the wrong documents")
+	}
+	if res := store.Search(nil, 10); len(res) != 1 || res[0].Chunk.Content != "new" {
+		t.Fatalf("expected only the new chunk to remain, got %+v", res)
+	}
+}
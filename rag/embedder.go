@@ -2,15 +2,80 @@ package rag
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
+	"math"
+	"math/rand/v2"
+	"net/http"
 	"os"
+	"strconv"
+	"sync"
+	"time"
 
 	openai "github.com/sashabaranov/go-openai"
 )
 
 // Embedder is an interface so later you can swap implementation.
+//
+// Embed returns an error instead of a missing vector, so callers never
+// index or search with a broken embedding. It stops when ctx is done.
 type Embedder interface {
-	Embed(text string) []float64
+	Embed(ctx context.Context, text string) ([]float64, error)
+}
+
+// ErrEmbedderUnavailable marks failures that are worth retrying later:
+// the embedding service is down, overloaded or not configured.
+// Other embedding errors mean the service gave an unusable answer.
+var ErrEmbedderUnavailable = errors.New("embedder unavailable")
+
+// ModelNamer is implemented by embedders that can name the model behind
+// their vectors. Vectors from different models are not comparable, so
+// chunks record it.
+type ModelNamer interface {
+	Model() string
+}
+
+// BatchEmbedder is implemented by embedders that embed many texts in one
+// round trip. EmbedBatch returns one vector per text, in order, or an
+// error and no vectors.
+type BatchEmbedder interface {
+	EmbedBatch(ctx context.Context, texts []string) ([][]float64, error)
+}
+
+// EmbedAll embeds texts with one EmbedBatch call when e supports it, and
+// one Embed call per text otherwise.
+func EmbedAll(ctx context.Context, e Embedder, texts []string) ([][]float64, error) {
+	if len(texts) == 0 {
+		return nil, nil
+	}
+	if b, ok := e.(BatchEmbedder); ok {
+		out, err := b.EmbedBatch(ctx, texts)
+		if err == nil && len(out) != len(texts) {
+			err = fmt.Errorf("embedder returned %d vectors for %d texts", len(out), len(texts))
+		}
+		if err != nil {
+			return nil, err
+		}
+		return out, nil
+	}
+	out := make([][]float64, len(texts))
+	for i, text := range texts {
+		v, err := e.Embed(ctx, text)
+		if err != nil {
+			return nil, fmt.Errorf("text %d: %w", i+1, err)
+		}
+		out[i] = v
+	}
+	return out, nil
+}
+
+// EmbedderModel returns the model name of e, or "" if it does not say.
+func EmbedderModel(e Embedder) string {
+	if m, ok := e.(ModelNamer); ok {
+		return m.Model()
+	}
+	return ""
 }
 
 // ---- Simple fake embedder (old one, kept for reference/testing) ----
@@ -21,7 +86,9 @@ func NewSimpleEmbedder() *SimpleEmbedder {
 	return &SimpleEmbedder{}
 }
 
-func (e *SimpleEmbedder) Embed(text string) []float64 {
+func (e *SimpleEmbedder) Model() string { return "simple" }
+
+func (e *SimpleEmbedder) Embed(ctx context.Context, text string) ([]float64, error) {
 	// Fake 4D vector: length, vowels, consonants, spaces.
 	var length, vowels, consonants, spaces float64
 	for _, r := range text {
@@ -36,14 +103,57 @@ func (e *SimpleEmbedder) Embed(text string) []float64 {
 			consonants++
 		}
 	}
-	return []float64{length, vowels, consonants, spaces}
+	return []float64{length, vowels, consonants, spaces}, nil
 }
 
 // ---- Real OpenAI embedder ----
 
+const (
+	defaultOpenAIBatchSize   = 256
+	defaultOpenAIBatchTokens = 100_000
+	defaultOpenAITimeout     = 30 * time.Second
+	defaultOpenAIMaxRetries  = 3
+	defaultOpenAIBackoff     = 500 * time.Millisecond
+	defaultOpenAIMaxBackoff  = 20 * time.Second
+)
+
+// OpenAIEmbedder calls the OpenAI embeddings API. Requests that fail with
+// a rate limit (429), a server error (5xx), a timeout or a network error
+// are retried with exponential backoff and jitter, waiting as long as the
+// Retry-After header asks when there is one.
+//
+// Set the exported fields before the first call.
 type OpenAIEmbedder struct {
 	client *openai.Client
 	model  openai.EmbeddingModel
+	apiKey bool
+
+	// BatchSize caps how many texts EmbedBatch sends per request
+	// (default 256; the API accepts up to 2048).
+	BatchSize int
+	// BatchTokens caps the tokens per request, as counted by CountTokens
+	// (default 100000; the API accepts up to 300000).
+	BatchTokens int
+
+	// Timeout bounds each attempt (default 30s). The caller's context
+	// bounds the whole call, retries included.
+	Timeout time.Duration
+	// MaxRetries is how many times a failed request is retried
+	// (default 3; negative means never).
+	MaxRetries int
+	// Backoff is the delay before the first retry, doubled for each one
+	// after it up to MaxBackoff (defaults 500ms and 20s). The actual
+	// delay is picked at random between half and all of it. A Retry-After
+	// longer than MaxBackoff is not waited for: the call fails instead.
+	Backoff, MaxBackoff time.Duration
+
+	// RequestsPerMinute and TokensPerMinute throttle requests on our side,
+	// before the API does (0 means no limit). Tokens are counted with
+	// CountTokens; retries count too.
+	RequestsPerMinute, TokensPerMinute int
+
+	limitOnce        sync.Once
+	requests, tokens *tokenBucket
 }
 
 // NewOpenAIEmbedder uses OPENAI_API_KEY from the environment
@@ -53,39 +163,242 @@ func NewOpenAIEmbedder() *OpenAIEmbedder {
 	if apiKey == "" {
 		log.Println("[OpenAIEmbedder] WARNING: OPENAI_API_KEY not set; Embed() will fail")
 	}
+	return newOpenAIEmbedder(openai.DefaultConfig(apiKey), apiKey != "")
+}
 
-	client := openai.NewClient(apiKey)
-
+func newOpenAIEmbedder(config openai.ClientConfig, apiKey bool) *OpenAIEmbedder {
+	config.HTTPClient = retryAfterRecorder{config.HTTPClient}
 	return &OpenAIEmbedder{
-		client: client,
+		client: openai.NewClientWithConfig(config),
 		model:  openai.SmallEmbedding3, // "text-embedding-3-small"
+		apiKey: apiKey,
 	}
 }
 
-func (e *OpenAIEmbedder) Embed(text string) []float64 {
-	if text == "" {
-		return nil
+func (e *OpenAIEmbedder) Model() string { return string(e.model) }
+
+func (e *OpenAIEmbedder) Embed(ctx context.Context, text string) ([]float64, error) {
+	out, err := e.embed(ctx, []string{text})
+	if err != nil {
+		return nil, err
+	}
+	return out[0], nil
+}
+
+// EmbedBatch embeds texts in as few requests as BatchSize and BatchTokens
+// allow, sent one after the other.
+func (e *OpenAIEmbedder) EmbedBatch(ctx context.Context, texts []string) ([][]float64, error) {
+	size := e.BatchSize
+	if size <= 0 {
+		size = defaultOpenAIBatchSize
+	}
+	maxTokens := e.BatchTokens
+	if maxTokens <= 0 {
+		maxTokens = defaultOpenAIBatchTokens
+	}
+
+	out := make([][]float64, 0, len(texts))
+	for start := 0; start < len(texts); {
+		end, tokens := start, 0
+		for end < len(texts) && end-start < size {
+			n := CountTokens(texts[end])
+			// A single text over the limit still goes, alone.
+			if end > start && tokens+n > maxTokens {
+				break
+			}
+			tokens += n
+			end++
+		}
+		batch, err := e.embed(ctx, texts[start:end])
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, batch...)
+		start = end
+	}
+	return out, nil
+}
+
+// embed sends texts in one request.
+func (e *OpenAIEmbedder) embed(ctx context.Context, texts []string) ([][]float64, error) {
+	for _, text := range texts {
+		if text == "" {
+			return nil, errors.New("openai: nothing to embed")
+		}
+	}
+	if !e.apiKey {
+		return nil, fmt.Errorf("%w: OPENAI_API_KEY not set", ErrEmbedderUnavailable)
 	}
 
 	req := openai.EmbeddingRequestStrings{
-		Input: []string{text},
+		Input: texts,
 		Model: e.model,
 	}
 
-	resp, err := e.client.CreateEmbeddings(context.Background(), req)
+	resp, err := e.send(ctx, req)
 	if err != nil {
-		log.Printf("[OpenAIEmbedder] error creating embedding: %v\n", err)
-		return nil
+		return nil, err
+	}
+
+	if len(resp.Data) != len(texts) {
+		return nil, fmt.Errorf("openai: got %d embeddings for %d texts", len(resp.Data), len(texts))
 	}
+	out := make([][]float64, len(texts))
+	for _, d := range resp.Data {
+		if d.Index < 0 || d.Index >= len(texts) || out[d.Index] != nil || len(d.Embedding) == 0 {
+			return nil, errors.New("openai: response holds a missing or misplaced embedding")
+		}
+		embedding := make([]float64, len(d.Embedding)) // from []float32
+		for i, v := range d.Embedding {
+			embedding[i] = float64(v)
+		}
+		out[d.Index] = embedding
+	}
+	return out, nil
+}
+
+// send makes the request, throttled and retried as configured.
+func (e *OpenAIEmbedder) send(ctx context.Context, req openai.EmbeddingRequestStrings) (openai.EmbeddingResponse, error) {
+	e.limitOnce.Do(func() {
+		e.requests = newTokenBucket(e.RequestsPerMinute)
+		e.tokens = newTokenBucket(e.TokensPerMinute)
+	})
+	tokens := 0
+	if e.tokens != nil {
+		for _, text := range req.Input {
+			tokens += CountTokens(text)
+		}
+	}
+	timeout := e.Timeout
+	if timeout <= 0 {
+		timeout = defaultOpenAITimeout
+	}
+	retries := e.MaxRetries
+	if retries == 0 {
+		retries = defaultOpenAIMaxRetries
+	}
+
+	for attempt := 0; ; attempt++ {
+		if err := e.requests.wait(ctx, 1); err != nil {
+			return openai.EmbeddingResponse{}, openAIError(err)
+		}
+		if err := e.tokens.wait(ctx, tokens); err != nil {
+			e.requests.refund(1)
+			return openai.EmbeddingResponse{}, openAIError(err)
+		}
+
+		var retryAfter time.Duration
+		actx, cancel := context.WithTimeout(context.WithValue(ctx, retryAfterKey{}, &retryAfter), timeout)
+		resp, err := e.client.CreateEmbeddings(actx, req)
+		cancel()
+		if err == nil {
+			return resp, nil
+		}
+		err = openAIError(err)
+		if attempt >= retries || !errors.Is(err, ErrEmbedderUnavailable) || ctx.Err() != nil {
+			return openai.EmbeddingResponse{}, err
+		}
+
+		delay := retryAfter
+		if delay <= 0 {
+			delay = e.backoff(attempt)
+		}
+		if delay > e.maxBackoff() {
+			log.Printf("error - openai: attempt %d failed, not waiting %v as asked: %v", attempt+1, delay, err)
+			return openai.EmbeddingResponse{}, err
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			// Waiting would outlive the caller; give up now.
+			return openai.EmbeddingResponse{}, err
+		}
+		log.Printf("error - openai: attempt %d failed, retrying in %v: %v", attempt+1, delay, err)
+		if err := sleep(ctx, delay); err != nil {
+			return openai.EmbeddingResponse{}, openAIError(err)
+		}
+	}
+}
+
+// backoff is the delay before retry number attempt+1: Backoff doubled
+// attempt times, capped at MaxBackoff, with the lower half randomized.
+func (e *OpenAIEmbedder) backoff(attempt int) time.Duration {
+	base, ceiling := e.Backoff, e.maxBackoff()
+	if base <= 0 {
+		base = defaultOpenAIBackoff
+	}
+	d := ceiling
+	if attempt < 30 {
+		d = min(ceiling, base<<attempt)
+	}
+	return d/2 + rand.N(d/2+1)
+}
 
-	if len(resp.Data) == 0 {
-		return nil
+// maxBackoff is the longest delay before a retry.
+func (e *OpenAIEmbedder) maxBackoff() time.Duration {
+	if e.MaxBackoff <= 0 {
+		return defaultOpenAIMaxBackoff
 	}
+	return e.MaxBackoff
+}
 
-	embedding := resp.Data[0].Embedding // []float32
-	out := make([]float64, len(embedding))
-	for i, v := range embedding {
-		out[i] = float64(v)
+// retryAfterKey carries a *time.Duration through a request's context, for
+// retryAfterRecorder to fill in.
+type retryAfterKey struct{}
+
+// retryAfterRecorder reads the Retry-After header of failed responses,
+// which the openai client does not expose in its errors.
+type retryAfterRecorder struct {
+	next openai.HTTPDoer
+}
+
+func (r retryAfterRecorder) Do(req *http.Request) (*http.Response, error) {
+	resp, err := r.next.Do(req)
+	if err != nil || resp.StatusCode < 400 {
+		return resp, err
+	}
+	if dst, ok := req.Context().Value(retryAfterKey{}).(*time.Duration); ok {
+		*dst = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	}
+	return resp, err
+}
+
+// parseRetryAfter reads a Retry-After value, either seconds or an HTTP
+// date. It returns 0 when there is none or it cannot be read, and the
+// longest Duration for delays beyond it.
+func parseRetryAfter(v string, now time.Time) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.ParseFloat(v, 64); err == nil {
+		if !(secs > 0) { // negative or NaN
+			return 0
+		}
+		if secs >= float64(math.MaxInt64)/float64(time.Second) {
+			return math.MaxInt64
+		}
+		return time.Duration(secs * float64(time.Second))
+	}
+	if t, err := http.ParseTime(v); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+// openAIError wraps an API failure, marking rate limits, server errors,
+// timeouts and network trouble as ErrEmbedderUnavailable.
+func openAIError(err error) error {
+	var apiErr *openai.APIError
+	var reqErr *openai.RequestError
+	status := 0
+	switch {
+	case errors.As(err, &apiErr):
+		status = apiErr.HTTPStatusCode
+	case errors.As(err, &reqErr):
+		status = reqErr.HTTPStatusCode
+	}
+	switch {
+	case status == http.StatusTooManyRequests, status >= 500,
+		status == 0 && !errors.Is(err, context.Canceled):
+		return fmt.Errorf("%w: openai: %w", ErrEmbedderUnavailable, err)
 	}
-	return out
+	return fmt.Errorf("openai: %w", err)
 }
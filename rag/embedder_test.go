@@ -1,6 +1,29 @@
 package rag
 
-import "testing"
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+func mustEmbed(t *testing.T, e Embedder, text string) []float64 {
+	t.Helper()
+	v, err := e.Embed(context.Background(), text)
+	if err != nil {
+		t.Fatalf("Embed(%q): %v", text, err)
+	}
+	return v
+}
 
 // TODO - Tests for OpenAI embedder
 
@@ -8,8 +31,8 @@ func TestSimpleEmbedder_Deterministic(t *testing.T) {
 	e := NewSimpleEmbedder()
 
 	text := "Go is great for AI."
-	v1 := e.Embed(text)
-	v2 := e.Embed(text)
+	v1 := mustEmbed(t, e, text)
+	v2 := mustEmbed(t, e, text)
 
 	if len(v1) == 0 {
 		t.Fatalf("expected non-empty embedding")
@@ -27,8 +50,8 @@ func TestSimpleEmbedder_Deterministic(t *testing.T) {
 func TestSimpleEmbedder_DifferentTextsDiffer(t *testing.T) {
 	e := NewSimpleEmbedder()
 
-	v1 := e.Embed("short")
-	v2 := e.Embed("a much longer string")
+	v1 := mustEmbed(t, e, "short")
+	v2 := mustEmbed(t, e, "a much longer string")
 
 	if len(v1) != len(v2) {
 		t.Fatalf("expected same dimension, got %d vs %d", len(v1), len(v2))
@@ -46,3 +69,311 @@ func TestSimpleEmbedder_DifferentTextsDiffer(t *testing.T) {
 		t.Fatalf("expected different embeddings for different texts")
 	}
 }
+
+func TestOpenAIError_Classifies(t *testing.T) {
+	tests := []struct {
+		err         error
+		unavailable bool
+	}{
+		{&openai.APIError{HTTPStatusCode: http.StatusTooManyRequests}, true},
+		{&openai.APIError{HTTPStatusCode: http.StatusServiceUnavailable}, true},
+		{&openai.RequestError{HTTPStatusCode: http.StatusBadGateway}, true},
+		{fmt.Errorf("dial tcp: %w", context.DeadlineExceeded), true},
+		{&openai.APIError{HTTPStatusCode: http.StatusBadRequest}, false},
+		{&openai.APIError{HTTPStatusCode: http.StatusUnauthorized}, false},
+		{context.Canceled, false},
+	}
+	for _, tt := range tests {
+		err := openAIError(tt.err)
+		if got := errors.Is(err, ErrEmbedderUnavailable); got != tt.unavailable {
+			t.Errorf("openAIError(%v): unavailable = %v, want %v", tt.err, got, tt.unavailable)
+		}
+		if !errors.Is(err, tt.err) {
+			t.Errorf("openAIError(%v) lost the cause: %v", tt.err, err)
+		}
+	}
+}
+
+func TestOpenAIEmbedder_NoAPIKey(t *testing.T) {
+	t.Setenv("OPENAI_API_KEY", "")
+	e := NewOpenAIEmbedder()
+	if _, err := e.Embed(context.Background(), "hello"); !errors.Is(err, ErrEmbedderUnavailable) {
+		t.Fatalf("expected ErrEmbedderUnavailable without a key, got %v", err)
+	}
+}
+
+// fakeOpenAI serves /embeddings like the OpenAI API, with one vector per
+// input ({length of the input}), listed in reverse to check that Index is
+// honoured. It records the size of every batch. The first requests are
+// answered by failures instead, one each, in order.
+func fakeOpenAI(t *testing.T, failures ...http.HandlerFunc) (*OpenAIEmbedder, *[]int) {
+	t.Helper()
+	var batches []int
+	var mu sync.Mutex
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/embeddings" {
+			http.NotFound(w, r)
+			return
+		}
+		mu.Lock()
+		var fail http.HandlerFunc
+		if len(failures) > 0 {
+			fail, failures = failures[0], failures[1:]
+		}
+		mu.Unlock()
+		if fail != nil {
+			fail(w, r)
+			return
+		}
+		var req struct {
+			Input []string `json:"input"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		batches = append(batches, len(req.Input))
+		mu.Unlock()
+		resp := openai.EmbeddingResponse{Object: "list"}
+		for i := len(req.Input) - 1; i >= 0; i-- {
+			resp.Data = append(resp.Data, openai.Embedding{Object: "embedding", Index: i, Embedding: []float32{float32(len(req.Input[i]))}})
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(srv.Close)
+
+	config := openai.DefaultConfig("test-key")
+	config.BaseURL = srv.URL + "/v1"
+	return newOpenAIEmbedder(config, true), &batches
+}
+
+func TestOpenAIEmbedder_EmbedBatch(t *testing.T) {
+	e, batches := fakeOpenAI(t)
+	e.BatchSize = 2
+
+	texts := []string{"a", "bb", "ccc", "dddd", "eeeee"}
+	got, err := e.EmbedBatch(context.Background(), texts)
+	if err != nil {
+		t.Fatalf("EmbedBatch: %v", err)
+	}
+	if fmt.Sprint(got) != "[[1] [2] [3] [4] [5]]" {
+		t.Fatalf("expected vectors in input order, got %v", got)
+	}
+	if fmt.Sprint(*batches) != "[2 2 1]" {
+		t.Fatalf("expected batches of at most 2, got %v", *batches)
+	}
+
+	if v, err := e.Embed(context.Background(), "hello"); err != nil || fmt.Sprint(v) != "[5]" {
+		t.Fatalf("Embed: got %v, %v", v, err)
+	}
+}
+
+func TestOpenAIEmbedder_BatchTokens(t *testing.T) {
+	e, batches := fakeOpenAI(t)
+	e.BatchTokens = 10
+
+	long := strings.Repeat("word ", 20) // well over 10 tokens
+	if _, err := e.EmbedBatch(context.Background(), []string{"one", "two", long, "three"}); err != nil {
+		t.Fatalf("EmbedBatch: %v", err)
+	}
+	// The long text goes alone; the short ones around it share requests.
+	if fmt.Sprint(*batches) != "[2 1 1]" {
+		t.Fatalf("expected batches [2 1 1], got %v", *batches)
+	}
+}
+
+// failWith answers like the API does when it refuses a request.
+func failWith(status int, retryAfter string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if retryAfter != "" {
+			w.Header().Set("Retry-After", retryAfter)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		fmt.Fprintf(w, `{"error":{"message":"status %d","type":"test"}}`, status)
+	}
+}
+
+func TestOpenAIEmbedder_Retries(t *testing.T) {
+	e, batches := fakeOpenAI(t,
+		failWith(http.StatusTooManyRequests, ""),
+		failWith(http.StatusInternalServerError, ""),
+		failWith(http.StatusServiceUnavailable, ""),
+	)
+	e.Backoff = time.Millisecond
+
+	v, err := e.Embed(context.Background(), "hello")
+	if err != nil || fmt.Sprint(v) != "[5]" {
+		t.Fatalf("expected success after 3 retries, got %v, %v", v, err)
+	}
+	if len(*batches) != 1 {
+		t.Fatalf("expected one successful request, got %v", *batches)
+	}
+}
+
+func TestOpenAIEmbedder_GivesUp(t *testing.T) {
+	var failures []http.HandlerFunc
+	for range 3 {
+		failures = append(failures, failWith(http.StatusBadGateway, ""))
+	}
+	e, _ := fakeOpenAI(t, failures...)
+	e.Backoff = time.Millisecond
+	e.MaxRetries = 2
+
+	if _, err := e.Embed(context.Background(), "hello"); !errors.Is(err, ErrEmbedderUnavailable) {
+		t.Fatalf("expected ErrEmbedderUnavailable after 2 retries, got %v", err)
+	}
+
+	// Errors that will not go away are not retried.
+	e, batches := fakeOpenAI(t, failWith(http.StatusBadRequest, ""))
+	e.Backoff = time.Millisecond
+	_, err := e.Embed(context.Background(), "hello")
+	if err == nil || errors.Is(err, ErrEmbedderUnavailable) {
+		t.Fatalf("expected a plain error for 400, got %v", err)
+	}
+	if _, err := e.Embed(context.Background(), "hello"); err != nil || len(*batches) != 1 {
+		t.Fatalf("expected the 400 to be sent once, got %v, %v", *batches, err)
+	}
+}
+
+func TestOpenAIEmbedder_HonoursRetryAfter(t *testing.T) {
+	e, _ := fakeOpenAI(t, failWith(http.StatusTooManyRequests, "0.2"))
+	e.Backoff = time.Millisecond
+
+	start := time.Now()
+	if _, err := e.Embed(context.Background(), "hello"); err != nil {
+		t.Fatalf("Embed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Fatalf("expected to wait for Retry-After, retried after %v", elapsed)
+	}
+
+	// A Retry-After beyond MaxBackoff or the caller's deadline fails at
+	// once.
+	for _, tc := range []struct {
+		retryAfter string
+		deadline   time.Duration
+	}{
+		{"60", 0},
+		{"1e20", 0},
+		{"3", time.Second},
+	} {
+		e, _ = fakeOpenAI(t, failWith(http.StatusTooManyRequests, tc.retryAfter))
+		ctx := context.Background()
+		if tc.deadline > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, tc.deadline)
+			defer cancel()
+		}
+		start = time.Now()
+		if _, err := e.Embed(ctx, "hello"); !errors.Is(err, ErrEmbedderUnavailable) {
+			t.Fatalf("Retry-After %s: expected ErrEmbedderUnavailable, got %v", tc.retryAfter, err)
+		}
+		if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+			t.Fatalf("Retry-After %s: expected to give up at once, took %v", tc.retryAfter, elapsed)
+		}
+	}
+}
+
+func TestOpenAIEmbedder_Timeout(t *testing.T) {
+	e, _ := fakeOpenAI(t, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	})
+	e.Timeout = 20 * time.Millisecond
+	e.Backoff = time.Millisecond
+
+	// The slow attempt times out and the retry succeeds.
+	if v, err := e.Embed(context.Background(), "hello"); err != nil || fmt.Sprint(v) != "[5]" {
+		t.Fatalf("expected success after a timeout, got %v, %v", v, err)
+	}
+}
+
+func TestOpenAIEmbedder_RateLimit(t *testing.T) {
+	e, batches := fakeOpenAI(t)
+	e.RequestsPerMinute = 1
+
+	if _, err := e.Embed(context.Background(), "one"); err != nil {
+		t.Fatalf("Embed: %v", err)
+	}
+	// The second request would have to wait a minute.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := e.Embed(ctx, "two"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the limiter to hold the request, got %v", err)
+	}
+	if len(*batches) != 1 {
+		t.Fatalf("expected one request to reach the API, got %v", *batches)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"3", 3 * time.Second},
+		{"0.5", 500 * time.Millisecond},
+		{"-1", 0},
+		{"Mon, 01 Jan 2024 12:00:10 GMT", 10 * time.Second},
+		{"Mon, 01 Jan 2024 11:00:00 GMT", 0},
+		{"soon", 0},
+		{"NaN", 0},
+		{"1e20", math.MaxInt64},
+		{"Inf", math.MaxInt64},
+	}
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.value, now); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestOpenAIEmbedder_Backoff(t *testing.T) {
+	e := &OpenAIEmbedder{Backoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	for attempt, full := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		full *= time.Millisecond
+		for range 20 {
+			if d := e.backoff(attempt); d < full/2 || d > full {
+				t.Fatalf("backoff(%d) = %v, want within [%v, %v]", attempt, d, full/2, full)
+			}
+		}
+	}
+	if d := e.backoff(100); d > time.Second {
+		t.Fatalf("backoff(100) = %v, want at most 1s", d)
+	}
+}
+
+// countingEmbedder embeds in batches and counts round trips.
+type countingEmbedder struct {
+	fakeEmbedder
+	batches int
+}
+
+func (c *countingEmbedder) EmbedBatch(ctx context.Context, texts []string) ([][]float64, error) {
+	c.batches++
+	out := make([][]float64, len(texts))
+	for i := range texts {
+		out[i] = []float64{float64(i)}
+	}
+	return out, nil
+}
+
+func TestChunkDocument_UsesBatchEmbedder(t *testing.T) {
+	e := &countingEmbedder{}
+	chunks, err := ChunkDocument(context.Background(), &SentenceChunker{MaxSentences: 1}, Document{ID: "d", Text: "One. Two. Three."}, e)
+	if err != nil {
+		t.Fatalf("ChunkDocument: %v", err)
+	}
+	if len(chunks) != 3 || e.batches != 1 {
+		t.Fatalf("expected 3 chunks from 1 batch, got %d chunks from %d", len(chunks), e.batches)
+	}
+	if chunks[2].Embedding[0] != 2 {
+		t.Fatalf("expected vectors matched to chunks in order, got %v", chunks[2].Embedding)
+	}
+}
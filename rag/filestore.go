@@ -0,0 +1,611 @@
+package rag
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	logFileName      = "store.log"
+	snapshotFileName = "store.snapshot"
+
+	defaultSnapshotEvery = 1000
+)
+
+// maxRecordSize bounds a record both ways: writes fail instead of writing
+// what reads would refuse, and reads guard against garbage lengths from a
+// torn header. A variable so tests can lower it.
+var maxRecordSize = 1 << 30
+
+var errRecordTooLarge = errors.New("record too large")
+
+// ErrStoreClosed is returned by writes to a FileStore after Close, such as
+// those racing with the deletion of its collection.
+var ErrStoreClosed = errors.New("store is closed")
+
+// FileStore is an InMemoryStore that survives restarts. Every write is
+// appended to a log in dir and fsynced before it is applied in memory;
+// every SnapshotEvery writes the whole store is written to a snapshot and
+// the log starts over. On open, the snapshot is loaded and the log replayed.
+// Snapshots are written without blocking writers: only copying the chunks
+// holds the lock.
+//
+// Records are framed as [length uint32][crc32 uint32][JSON payload]. A
+// record that is cut short or fails its checksum (a crash mid-write) ends
+// the replay, and the log is truncated back to the last good record. A
+// snapshot is a header record followed by one record per chunk.
+type FileStore struct {
+	mem *InMemoryStore
+
+	mu            sync.Mutex // serializes writers: log order == apply order
+	dir           string
+	log           *os.File
+	logSize       int64  // end of the last complete record in the log
+	seq           uint64 // sequence number of the last logged operation
+	sinceSnapshot int
+	snapshotEvery int
+
+	snapshotMu sync.Mutex // held while a snapshot is written
+	closed     bool       // guarded by mu
+}
+
+var (
+	_ VectorStore = (*FileStore)(nil)
+	_ Counter     = (*FileStore)(nil)
+)
+
+// FileStoreOptions tunes OpenFileStore. Zero values use the defaults.
+type FileStoreOptions struct {
+	// SnapshotEvery is how many logged writes trigger a new snapshot.
+	SnapshotEvery int
+	// HNSW, when set, serves searches from an HNSW index (see NewHNSWStore)
+	// rebuilt from the data on open.
+	HNSW *HNSWOptions
+}
+
+// logOp is one logged write.
+type logOp struct {
+	Seq    uint64   `json:"seq"`
+	Op     string   `json:"op"` // add, replace, delete, delete_document, delete_source, clear
+	DocID  string   `json:"doc_id,omitempty"`
+	Source string   `json:"source,omitempty"`
+	Chunks []Chunk  `json:"chunks,omitempty"`
+	IDs    []string `json:"ids,omitempty"`
+}
+
+// snapshot heads a snapshot file: the store as of operation Seq, whose
+// Count chunks follow one record each.
+type snapshot struct {
+	Seq   uint64 `json:"seq"`
+	Count int    `json:"count,omitempty"`
+}
+
+// OpenFileStore opens (or creates) the store kept in dir.
+func OpenFileStore(dir string, opts FileStoreOptions) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create data dir: %w", err)
+	}
+	s := &FileStore{
+		mem:           NewInMemoryStore(),
+		dir:           dir,
+		snapshotEvery: opts.SnapshotEvery,
+	}
+	if s.snapshotEvery <= 0 {
+		s.snapshotEvery = defaultSnapshotEvery
+	}
+	if opts.HNSW != nil {
+		s.mem = NewHNSWStore(*opts.HNSW)
+	}
+
+	if err := s.loadSnapshot(); err != nil {
+		return nil, err
+	}
+	replayed, err := s.replayLog()
+	if err != nil {
+		return nil, err
+	}
+
+	s.log, err = os.OpenFile(filepath.Join(dir, logFileName), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open log: %w", err)
+	}
+	info, err := s.log.Stat()
+	if err != nil {
+		s.log.Close()
+		return nil, fmt.Errorf("stat log: %w", err)
+	}
+	s.logSize = info.Size()
+	// Fold the replayed log into a fresh snapshot so the next start is fast.
+	if replayed > 0 {
+		if err := s.writeSnapshot(); err != nil {
+			s.log.Close()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Close releases the log file. Reads still see the data; writes fail with
+// ErrStoreClosed.
+func (s *FileStore) Close() error {
+	s.snapshotMu.Lock() // let a snapshot in progress finish
+	defer s.snapshotMu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	return s.log.Close()
+}
+
+func (s *FileStore) loadSnapshot() error {
+	f, err := os.Open(filepath.Join(s.dir, snapshotFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("open snapshot: %w", err)
+	}
+	defer f.Close()
+
+	// Snapshots are written to a temp file and renamed, so a bad one is
+	// real corruption, not a crash: refuse to start with half the data.
+	r := bufio.NewReader(f)
+	payload, err := readRecord(r)
+	if err != nil {
+		return fmt.Errorf("read snapshot: %w", err)
+	}
+	var snap snapshot
+	if err := json.Unmarshal(payload, &snap); err != nil {
+		return fmt.Errorf("decode snapshot: %w", err)
+	}
+	var chunks []Chunk
+	for i := range snap.Count {
+		payload, err := readRecord(r)
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return fmt.Errorf("read snapshot chunk %d of %d: %w", i+1, snap.Count, err)
+		}
+		var c Chunk
+		if err := json.Unmarshal(payload, &c); err != nil {
+			return fmt.Errorf("decode snapshot chunk %d: %w", i+1, err)
+		}
+		chunks = append(chunks, c)
+	}
+	s.seq = snap.Seq
+	s.mem.Add(chunks...)
+	return nil
+}
+
+// replayLog applies logged operations newer than the snapshot and returns
+// how many it applied.
+func (s *FileStore) replayLog() (int, error) {
+	path := filepath.Join(s.dir, logFileName)
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("open log: %w", err)
+	}
+	defer f.Close()
+
+	r := &countingReader{r: bufio.NewReader(f)}
+	var good int64
+	replayed := 0
+	for {
+		payload, err := readRecord(r)
+		if err == io.EOF {
+			break
+		}
+		var op logOp
+		if err == nil {
+			err = json.Unmarshal(payload, &op)
+		}
+		if err != nil {
+			log.Printf("[FileStore] truncating %s at byte %d: %v", path, good, err)
+			if err := f.Truncate(good); err != nil {
+				return 0, fmt.Errorf("truncate log: %w", err)
+			}
+			if err := f.Sync(); err != nil {
+				return 0, fmt.Errorf("sync log: %w", err)
+			}
+			break
+		}
+		good = r.n
+		// The snapshot may already include the start of the log if we
+		// crashed between writing it and truncating the log.
+		if op.Seq <= s.seq {
+			continue
+		}
+		s.apply(op)
+		s.seq = op.Seq
+		replayed++
+	}
+	return replayed, nil
+}
+
+func (s *FileStore) apply(op logOp) {
+	switch op.Op {
+	case "add":
+		s.mem.Add(op.Chunks...)
+	case "replace":
+		s.mem.AddDocument(op.DocID, ConflictReplace, op.Chunks...)
+	case "delete":
+		s.mem.Delete(op.IDs...)
+	case "delete_document":
+		s.mem.DeleteDocument(op.DocID)
+	case "delete_source":
+		s.mem.DeleteSource(op.Source)
+	case "clear":
+		s.mem.Clear()
+	}
+}
+
+// update runs fn, which commits, under s.mu, then writes a snapshot if
+// one is due.
+func (s *FileStore) update(fn func() error) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrStoreClosed
+	}
+	err := fn()
+	due := s.sinceSnapshot >= s.snapshotEvery
+	s.mu.Unlock()
+
+	// The write itself is durable already; a failed snapshot only means
+	// a longer replay next time.
+	if due && s.snapshotMu.TryLock() { // one snapshot at a time
+		defer s.snapshotMu.Unlock()
+		if err := s.writeSnapshot(); err != nil {
+			log.Printf("[FileStore] snapshot failed: %v", err)
+		}
+	}
+	return err
+}
+
+// commit logs op and applies it in memory. The caller holds s.mu.
+func (s *FileStore) commit(op logOp) error {
+	op.Seq = s.seq + 1
+	payload, err := json.Marshal(op)
+	if err != nil {
+		return fmt.Errorf("encode log record: %w", err)
+	}
+	if err := writeRecord(s.log, payload); err != nil {
+		// Drop the partial record, or the next writes would land behind it
+		// and be lost on replay.
+		s.log.Truncate(s.logSize)
+		return fmt.Errorf("write log: %w", err)
+	}
+	if err := s.log.Sync(); err != nil {
+		s.log.Truncate(s.logSize)
+		return fmt.Errorf("sync log: %w", err)
+	}
+	s.logSize += int64(8 + len(payload))
+	s.seq = op.Seq
+	s.apply(op)
+	s.sinceSnapshot++
+	return nil
+}
+
+// writeSnapshot saves the whole store and drops the logged operations it
+// includes. It takes s.mu only to copy the store and to rewrite the log,
+// so writers carry on meanwhile. Callers must not run two at once.
+func (s *FileStore) writeSnapshot() error {
+	s.mu.Lock()
+	snap := snapshot{Seq: s.seq}
+	chunks := s.mem.List()
+	logEnd, ops := s.logSize, s.sinceSnapshot
+	s.mu.Unlock()
+	snap.Count = len(chunks)
+
+	tmp, err := os.CreateTemp(s.dir, snapshotFileName+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("create snapshot: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+
+	if err := writeSnapshotRecords(tmp, snap, chunks); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("sync snapshot: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close snapshot: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(s.dir, snapshotFileName)); err != nil {
+		return fmt.Errorf("rename snapshot: %w", err)
+	}
+	if err := syncDir(s.dir); err != nil {
+		return err
+	}
+
+	// Records up to snap.Seq are in the snapshot now. If we crash before
+	// the log is cut, replay skips them by sequence number.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.dropLogPrefix(logEnd); err != nil {
+		return err
+	}
+	s.sinceSnapshot -= ops
+	return nil
+}
+
+// writeSnapshotRecords writes the header and then each chunk.
+func writeSnapshotRecords(w io.Writer, snap snapshot, chunks []Chunk) error {
+	bw := bufio.NewWriter(w)
+	payload, err := json.Marshal(snap)
+	if err != nil {
+		return fmt.Errorf("encode snapshot: %w", err)
+	}
+	if err := writeRecord(bw, payload); err != nil {
+		return fmt.Errorf("write snapshot: %w", err)
+	}
+	for _, c := range chunks {
+		payload, err := json.Marshal(c)
+		if err != nil {
+			return fmt.Errorf("encode snapshot chunk %q: %w", c.ID, err)
+		}
+		if err := writeRecord(bw, payload); err != nil {
+			return fmt.Errorf("write snapshot chunk %q: %w", c.ID, err)
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("write snapshot: %w", err)
+	}
+	return nil
+}
+
+// dropLogPrefix removes the first n bytes of the log: truncating it when
+// nothing was logged after them, or else copying the rest into a new log
+// that replaces it. The caller holds s.mu.
+func (s *FileStore) dropLogPrefix(n int64) error {
+	if n == s.logSize {
+		if err := s.log.Truncate(0); err != nil {
+			return fmt.Errorf("truncate log: %w", err)
+		}
+		if err := s.log.Sync(); err != nil {
+			return fmt.Errorf("sync log: %w", err)
+		}
+		s.logSize = 0
+		return nil
+	}
+
+	path := filepath.Join(s.dir, logFileName)
+	old, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open log: %w", err)
+	}
+	defer old.Close()
+	tmp, err := os.CreateTemp(s.dir, logFileName+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("create log: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+	if _, err := io.Copy(tmp, io.NewSectionReader(old, n, s.logSize-n)); err != nil {
+		tmp.Close()
+		return fmt.Errorf("copy log: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("sync log: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close log: %w", err)
+	}
+	// Open the new log before it takes the old one's place, so that
+	// writes never go on to a file that is no longer the log.
+	f, err := os.OpenFile(tmp.Name(), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return fmt.Errorf("open log: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		f.Close()
+		return fmt.Errorf("rename log: %w", err)
+	}
+	s.log.Close()
+	s.log = f
+	s.logSize -= n
+	return syncDir(s.dir)
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("open data dir: %w", err)
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("sync data dir: %w", err)
+	}
+	return nil
+}
+
+var errBadChecksum = errors.New("checksum mismatch")
+
+func writeRecord(w io.Writer, payload []byte) error {
+	if len(payload) > maxRecordSize {
+		return fmt.Errorf("%w: %d bytes, the limit is %d", errRecordTooLarge, len(payload), maxRecordSize)
+	}
+	buf := make([]byte, 8+len(payload))
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(buf[4:8], crc32.ChecksumIEEE(payload))
+	copy(buf[8:], payload)
+	_, err := w.Write(buf)
+	return err
+}
+
+// readRecord returns the next record's payload, io.EOF at a clean end, and
+// io.ErrUnexpectedEOF or errBadChecksum for a damaged record.
+func readRecord(r io.Reader) ([]byte, error) {
+	var header [8]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	size := binary.LittleEndian.Uint32(header[0:4])
+	if int64(size) > int64(maxRecordSize) {
+		return nil, fmt.Errorf("record size %d too large", size)
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(header[4:8]) {
+		return nil, errBadChecksum
+	}
+	return payload, nil
+}
+
+// countingReader tracks how many bytes were consumed, i.e. the offset of
+// the end of the last complete record.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// ---- VectorStore ----
+
+func (s *FileStore) Add(chunks ...Chunk) error {
+	if len(chunks) == 0 {
+		return nil
+	}
+	return s.update(func() error {
+		return s.commit(logOp{Op: "add", Chunks: chunks})
+	})
+}
+
+func (s *FileStore) AddDocument(docID string, policy ConflictPolicy, chunks ...Chunk) (int, error) {
+	var replaced int
+	err := s.update(func() error {
+		// Writers are serialized by s.mu, so the answer cannot change before commit.
+		replaced = s.mem.countDocument(docID)
+		if replaced > 0 && policy != ConflictReplace {
+			return fmt.Errorf("%w: %q", ErrDocumentExists, docID)
+		}
+		op := "add"
+		if replaced > 0 {
+			op = "replace"
+		}
+		return s.commit(logOp{Op: op, DocID: docID, Chunks: chunks})
+	})
+	if err != nil {
+		return 0, err
+	}
+	return replaced, nil
+}
+
+func (s *FileStore) HasDocument(docID string) bool {
+	return s.mem.HasDocument(docID)
+}
+
+func (s *FileStore) Search(queryEmbedding []float64, topK int) []SearchResult {
+	return s.mem.Search(queryEmbedding, topK)
+}
+
+func (s *FileStore) SearchWith(queryEmbedding []float64, topK int, opts SearchOptions) []SearchResult {
+	return s.mem.SearchWith(queryEmbedding, topK, opts)
+}
+
+func (s *FileStore) KeywordSearch(query string, topK int, opts SearchOptions) []SearchResult {
+	return s.mem.KeywordSearch(query, topK, opts)
+}
+
+func (s *FileStore) CountMatches(q CountQuery) (int, bool) {
+	return s.mem.CountMatches(q)
+}
+
+func (s *FileStore) Delete(ids ...string) (int, error) {
+	var found int
+	err := s.update(func() error {
+		found = s.mem.countIDs(ids)
+		if found == 0 {
+			return nil
+		}
+		return s.commit(logOp{Op: "delete", IDs: ids})
+	})
+	if err != nil {
+		return 0, err
+	}
+	return found, nil
+}
+
+func (s *FileStore) DeleteDocument(docID string) (int, error) {
+	var found int
+	err := s.update(func() error {
+		found = s.mem.countDocument(docID)
+		if found == 0 {
+			return nil
+		}
+		return s.commit(logOp{Op: "delete_document", DocID: docID})
+	})
+	if err != nil {
+		return 0, err
+	}
+	return found, nil
+}
+
+func (s *FileStore) DeleteSource(source string) (int, error) {
+	var found int
+	err := s.update(func() error {
+		found = s.mem.countSource(source)
+		if found == 0 {
+			return nil
+		}
+		return s.commit(logOp{Op: "delete_source", Source: source})
+	})
+	if err != nil {
+		return 0, err
+	}
+	return found, nil
+}
+
+func (s *FileStore) Clear() error {
+	return s.update(func() error {
+		return s.commit(logOp{Op: "clear"})
+	})
+}
+
+func (s *FileStore) Count() int {
+	return s.mem.Count()
+}
+
+func (s *FileStore) List() []Chunk {
+	return s.mem.List()
+}
+
+func (s *FileStore) Documents() []DocumentInfo {
+	return s.mem.Documents()
+}
+
+func (s *FileStore) DocumentChunks(docID string) []Chunk {
+	return s.mem.DocumentChunks(docID)
+}
+
+func (s *FileStore) Chunk(id string) (Chunk, bool) {
+	return s.mem.Chunk(id)
+}
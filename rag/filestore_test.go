@@ -0,0 +1,267 @@
+package rag
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func openTestFileStore(t *testing.T, dir string, opts FileStoreOptions) *FileStore {
+	t.Helper()
+	s, err := OpenFileStore(dir, opts)
+	if err != nil {
+		t.Fatalf("OpenFileStore: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func fileChunk(id, docID string, x, y float64) Chunk {
+	return Chunk{ID: id, DocumentID: docID, Content: id, Embedding: []float64{x, y}}
+}
+
+func TestFileStore_SurvivesReopen(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestFileStore(t, dir, FileStoreOptions{})
+	s.Add(fileChunk("a-0", "a", 1, 0), fileChunk("a-1", "a", 0, 1))
+	s.AddDocument("b", ConflictReject, fileChunk("b-0", "b", 1, 1))
+	s.AddDocument("a", ConflictReplace, fileChunk("a-0", "a", 1, 0))
+	s.Delete("b-0")
+	c := fileChunk("c-0", "c", 0, 1)
+	c.Source = "c.txt"
+	s.Add(c, fileChunk("d-0", "d", 1, 1))
+	s.DeleteSource("c.txt")
+	s.DeleteDocument("d")
+	s.Close()
+
+	s = openTestFileStore(t, dir, FileStoreOptions{})
+	if got := s.Count(); got != 1 {
+		t.Fatalf("expected 1 chunk after reopen, got %d", got)
+	}
+	res := s.Search([]float64{1, 0}, 1)
+	if len(res) != 1 || res[0].Chunk.ID != "a-0" {
+		t.Fatalf("unexpected search result after reopen: %+v", res)
+	}
+	if _, err := s.AddDocument("a", ConflictReject); err == nil {
+		t.Fatalf("expected conflict for a document restored from disk")
+	}
+}
+
+func TestFileStore_TruncatesTornRecord(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestFileStore(t, dir, FileStoreOptions{})
+	s.Add(fileChunk("1", "", 1, 0))
+	s.Add(fileChunk("2", "", 0, 1))
+	s.Close()
+
+	// Simulate a crash halfway through writing the second record.
+	path := filepath.Join(dir, logFileName)
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(path, info.Size()-5); err != nil {
+		t.Fatal(err)
+	}
+
+	s = openTestFileStore(t, dir, FileStoreOptions{})
+	if got := s.Count(); got != 1 {
+		t.Fatalf("expected the intact record only, got %d chunks", got)
+	}
+	// New writes must not end up behind the torn tail.
+	s.Add(fileChunk("3", "", 1, 1))
+	s.Close()
+
+	s = openTestFileStore(t, dir, FileStoreOptions{})
+	if got := s.Count(); got != 2 {
+		t.Fatalf("expected 2 chunks after second reopen, got %d", got)
+	}
+}
+
+func TestFileStore_StopsAtBadChecksum(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestFileStore(t, dir, FileStoreOptions{})
+	s.Add(fileChunk("1", "", 1, 0))
+	s.Add(fileChunk("2", "", 0, 1))
+	s.Close()
+
+	path := filepath.Join(dir, logFileName)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-2] ^= 0xff // flip a byte in the last payload
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	s = openTestFileStore(t, dir, FileStoreOptions{})
+	if got := s.Count(); got != 1 {
+		t.Fatalf("expected 1 chunk, got %d", got)
+	}
+}
+
+func TestFileStore_SnapshotCompactsLog(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestFileStore(t, dir, FileStoreOptions{SnapshotEvery: 3})
+	for _, id := range []string{"1", "2", "3", "4"} {
+		s.Add(fileChunk(id, "", 1, 0))
+	}
+
+	f, err := os.Open(filepath.Join(dir, snapshotFileName))
+	if err != nil {
+		t.Fatalf("expected a snapshot after 3 writes: %v", err)
+	}
+	defer f.Close()
+	payload, err := readRecord(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var snap snapshot
+	if err := json.Unmarshal(payload, &snap); err != nil {
+		t.Fatal(err)
+	}
+	chunks := 0
+	for {
+		if _, err := readRecord(f); err != nil {
+			break
+		}
+		chunks++
+	}
+	if snap.Seq != 3 || snap.Count != 3 || chunks != 3 {
+		t.Fatalf("expected snapshot of 3 chunks at seq 3, got %d (%d records) at seq %d", snap.Count, chunks, snap.Seq)
+	}
+
+	// Only the write after the snapshot is left in the log.
+	logFile, err := os.Open(filepath.Join(dir, logFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logFile.Close()
+	records := 0
+	for {
+		if _, err := readRecord(logFile); err != nil {
+			break
+		}
+		records++
+	}
+	if records != 1 {
+		t.Fatalf("expected 1 record in the log, got %d", records)
+	}
+	s.Close()
+
+	s = openTestFileStore(t, dir, FileStoreOptions{SnapshotEvery: 3})
+	if got := s.Count(); got != 4 {
+		t.Fatalf("expected 4 chunks after reopen, got %d", got)
+	}
+}
+
+func TestFileStore_SkipsRecordsAlreadyInSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestFileStore(t, dir, FileStoreOptions{})
+	s.Add(fileChunk("1", "", 1, 0))
+	s.Add(fileChunk("2", "", 0, 1))
+	logBefore, err := os.ReadFile(filepath.Join(dir, logFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.writeSnapshot(); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	// A crash between the snapshot rename and the log truncate leaves both.
+	if err := os.WriteFile(filepath.Join(dir, logFileName), logBefore, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	s = openTestFileStore(t, dir, FileStoreOptions{})
+	if got := s.Count(); got != 2 {
+		t.Fatalf("expected records not to be applied twice, got %d chunks", got)
+	}
+}
+
+func TestFileStore_SnapshotLargerThanRecordLimit(t *testing.T) {
+	defer func(n int) { maxRecordSize = n }(maxRecordSize)
+	maxRecordSize = 300
+
+	dir := t.TempDir()
+	s := openTestFileStore(t, dir, FileStoreOptions{SnapshotEvery: 20})
+	for i := range 20 {
+		if err := s.Add(fileChunk(fmt.Sprint(i), "", 1, 0)); err != nil {
+			t.Fatalf("Add %d: %v", i, err)
+		}
+	}
+	if info, err := os.Stat(filepath.Join(dir, snapshotFileName)); err != nil || info.Size() <= 300 {
+		t.Fatalf("expected a snapshot over the record limit, got %v, %v", info, err)
+	}
+
+	// A single record over the limit is refused, not written.
+	big := fileChunk("big", "", 1, 0)
+	big.Content = strings.Repeat("x", 400)
+	if err := s.Add(big); !errors.Is(err, errRecordTooLarge) {
+		t.Fatalf("expected errRecordTooLarge, got %v", err)
+	}
+	s.Close()
+
+	s = openTestFileStore(t, dir, FileStoreOptions{})
+	if got := s.Count(); got != 20 {
+		t.Fatalf("expected 20 chunks after reopen, got %d", got)
+	}
+}
+
+func TestFileStore_KeepsWritesLoggedDuringSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestFileStore(t, dir, FileStoreOptions{})
+	s.Add(fileChunk("1", "", 1, 0))
+	s.mu.Lock()
+	inSnapshot := s.logSize
+	s.mu.Unlock()
+	// Logged after the snapshot copied the store.
+	s.Add(fileChunk("2", "", 0, 1))
+
+	s.mu.Lock()
+	err := s.dropLogPrefix(inSnapshot)
+	s.mu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Add(fileChunk("3", "", 1, 1))
+	s.Close()
+
+	// Without the snapshot, only the records kept in the log come back.
+	s = openTestFileStore(t, dir, FileStoreOptions{})
+	if _, ok := s.Chunk("1"); ok {
+		t.Fatal("expected the dropped record gone from the log")
+	}
+	if s.Count() != 2 {
+		t.Fatalf("expected the later writes kept, got %d chunks", s.Count())
+	}
+}
+
+func TestFileStore_ConcurrentWritesAndSnapshots(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestFileStore(t, dir, FileStoreOptions{SnapshotEvery: 5})
+	var wg sync.WaitGroup
+	for w := range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 25 {
+				s.Add(fileChunk(fmt.Sprintf("%d-%d", w, i), "", 1, 0))
+			}
+		}()
+	}
+	wg.Wait()
+	s.Close()
+
+	s = openTestFileStore(t, dir, FileStoreOptions{})
+	if got := s.Count(); got != 100 {
+		t.Fatalf("expected 100 chunks after reopen, got %d", got)
+	}
+}
@@ -0,0 +1,206 @@
+package rag
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Filter restricts a search to chunks matching a boolean expression over
+// their fields. A node is either a combination of child filters (And, Or)
+// or a condition on one Field:
+//
+//	{"and": [
+//	  {"field": "source", "in": ["contract.pdf", "annex.pdf"]},
+//	  {"field": "attr.customer", "eq": "acme"},
+//	  {"or": [
+//	    {"field": "created_at", "gte": "2024-01-01"},
+//	    {"field": "attr.signed", "lt": "2023-06-30T12:00:00Z"}
+//	  ]}
+//	]}
+//
+// Fields are source, document_id, model, created_at, attr.<name> (uploader
+// attributes) and meta.<name> (chunker metadata). Eq and In compare text;
+// a missing attribute is the empty string. Gt, Gte, Lt and Lte compare
+// dates, written as RFC 3339 timestamps or YYYY-MM-DD; a value that is not
+// a date never matches a range.
+type Filter struct {
+	And []Filter `json:"and,omitempty"`
+	Or  []Filter `json:"or,omitempty"`
+
+	Field string   `json:"field,omitempty"`
+	Eq    *string  `json:"eq,omitempty"`
+	In    []string `json:"in,omitempty"`
+	Gt    string   `json:"gt,omitempty"`
+	Gte   string   `json:"gte,omitempty"`
+	Lt    string   `json:"lt,omitempty"`
+	Lte   string   `json:"lte,omitempty"`
+}
+
+// SearchOptions refines a search.
+type SearchOptions struct {
+	// Filter, when set, is applied before ranking: only matching chunks
+	// compete for the topK places.
+	Filter *Filter
+}
+
+// Validate reports the first problem in the expression, so callers can
+// reject a bad filter instead of silently matching nothing.
+func (f *Filter) Validate() error {
+	_, err := f.compile()
+	return err
+}
+
+// Match reports whether the chunk satisfies the filter. A nil filter
+// matches everything, an invalid one nothing. Stores compile the filter
+// once per search instead of calling Match for every chunk.
+func (f *Filter) Match(ch Chunk) bool {
+	return f.matcher()(ch)
+}
+
+// matcher compiles f into a predicate; nil matches everything.
+func (f *Filter) matcher() func(Chunk) bool {
+	if f == nil {
+		return func(Chunk) bool { return true }
+	}
+	match, err := f.compile()
+	if err != nil {
+		return func(Chunk) bool { return false }
+	}
+	return match
+}
+
+func (f *Filter) compile() (func(Chunk) bool, error) {
+	isLeaf := f.Field != ""
+	switch {
+	case len(f.And) > 0 && !isLeaf && len(f.Or) == 0:
+		return compileAll(f.And, "and", true)
+	case len(f.Or) > 0 && !isLeaf && len(f.And) == 0:
+		return compileAll(f.Or, "or", false)
+	case isLeaf && len(f.And) == 0 && len(f.Or) == 0:
+		return f.compileCondition()
+	}
+	return nil, errors.New("filter: each node needs exactly one of and, or, field")
+}
+
+func compileAll(children []Filter, op string, all bool) (func(Chunk) bool, error) {
+	preds := make([]func(Chunk) bool, len(children))
+	for i := range children {
+		p, err := children[i].compile()
+		if err != nil {
+			return nil, fmt.Errorf("%s[%d]: %w", op, i, err)
+		}
+		preds[i] = p
+	}
+	return func(ch Chunk) bool {
+		for _, p := range preds {
+			if p(ch) != all {
+				return !all
+			}
+		}
+		return all
+	}, nil
+}
+
+func (f *Filter) compileCondition() (func(Chunk) bool, error) {
+	value, err := fieldGetter(f.Field)
+	if err != nil {
+		return nil, err
+	}
+
+	hasRange := f.Gt != "" || f.Gte != "" || f.Lt != "" || f.Lte != ""
+	ops := 0
+	for _, set := range []bool{f.Eq != nil, f.In != nil, hasRange} {
+		if set {
+			ops++
+		}
+	}
+	if ops != 1 {
+		return nil, fmt.Errorf("filter on %q: need exactly one of eq, in, or a range (gt, gte, lt, lte)", f.Field)
+	}
+
+	switch {
+	case f.Eq != nil:
+		want := *f.Eq
+		return func(ch Chunk) bool { return value(ch) == want }, nil
+	case f.In != nil:
+		set := make(map[string]bool, len(f.In))
+		for _, v := range f.In {
+			set[v] = true
+		}
+		return func(ch Chunk) bool { return set[value(ch)] }, nil
+	}
+
+	type bound struct {
+		t      time.Time
+		cmp    int // required sign of value.Compare(t)
+		orSame bool
+	}
+	var bounds []bound
+	for _, b := range []struct {
+		text   string
+		cmp    int
+		orSame bool
+		name   string
+	}{
+		{f.Gt, 1, false, "gt"}, {f.Gte, 1, true, "gte"}, {f.Lt, -1, false, "lt"}, {f.Lte, -1, true, "lte"},
+	} {
+		if b.text == "" {
+			continue
+		}
+		t, ok := parseFilterTime(b.text)
+		if !ok {
+			return nil, fmt.Errorf("filter on %q: %s %q is not a date (RFC 3339 or YYYY-MM-DD)", f.Field, b.name, b.text)
+		}
+		bounds = append(bounds, bound{t, b.cmp, b.orSame})
+	}
+	timeOf := func(ch Chunk) (time.Time, bool) { return parseFilterTime(value(ch)) }
+	if f.Field == "created_at" {
+		timeOf = func(ch Chunk) (time.Time, bool) { return ch.CreatedAt, true }
+	}
+	return func(ch Chunk) bool {
+		t, ok := timeOf(ch)
+		if !ok {
+			return false
+		}
+		for _, b := range bounds {
+			c := t.Compare(b.t)
+			if c != b.cmp && !(b.orSame && c == 0) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+// fieldGetter returns how to read a filterable field from a chunk.
+func fieldGetter(field string) (func(Chunk) string, error) {
+	switch field {
+	case "source":
+		return func(ch Chunk) string { return ch.Source }, nil
+	case "document_id":
+		return func(ch Chunk) string { return ch.DocumentID }, nil
+	case "model":
+		return func(ch Chunk) string { return ch.Model }, nil
+	case "created_at":
+		return func(ch Chunk) string { return ch.CreatedAt.Format(time.RFC3339Nano) }, nil
+	}
+	if name, ok := strings.CutPrefix(field, "attr."); ok && name != "" {
+		return func(ch Chunk) string { return ch.Attributes[name] }, nil
+	}
+	if name, ok := strings.CutPrefix(field, "meta."); ok && name != "" {
+		return func(ch Chunk) string { return ch.Metadata[name] }, nil
+	}
+	return nil, fmt.Errorf("filter: unknown field %q", field)
+}
+
+func parseFilterTime(s string) (time.Time, bool) {
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, true
+	}
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t, true
+	}
+	return time.Time{}, false
+}
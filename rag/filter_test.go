@@ -0,0 +1,87 @@
+package rag
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func parseTestFilter(t *testing.T, s string) *Filter {
+	t.Helper()
+	var f Filter
+	if err := json.Unmarshal([]byte(s), &f); err != nil {
+		t.Fatalf("invalid filter json %s: %v", s, err)
+	}
+	if err := f.Validate(); err != nil {
+		t.Fatalf("Validate(%s): %v", s, err)
+	}
+	return &f
+}
+
+func TestFilter_Match(t *testing.T) {
+	ch := Chunk{
+		DocumentID: "contract-7",
+		Source:     "contract.pdf",
+		Attributes: map[string]string{"customer": "acme", "signed": "2024-03-01"},
+		Metadata:   map[string]string{MetaHeadingPath: "Terms"},
+		CreatedAt:  time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+	}
+	tests := []struct {
+		filter string
+		want   bool
+	}{
+		{`{"field":"source","eq":"contract.pdf"}`, true},
+		{`{"field":"source","eq":"other.pdf"}`, false},
+		{`{"field":"source","in":["a.pdf","contract.pdf"]}`, true},
+		{`{"field":"document_id","in":["a","b"]}`, false},
+		{`{"field":"attr.customer","eq":"acme"}`, true},
+		{`{"field":"attr.region","eq":""}`, true}, // missing attribute
+		{`{"field":"meta.heading_path","eq":"Terms"}`, true},
+		{`{"field":"created_at","gte":"2024-05-01T12:00:00Z"}`, true},
+		{`{"field":"created_at","gt":"2024-05-01T12:00:00Z"}`, false},
+		{`{"field":"created_at","gte":"2024-01-01","lt":"2024-06-01"}`, true},
+		{`{"field":"created_at","lte":"2024-04-30"}`, false},
+		{`{"field":"attr.signed","lt":"2024-03-02"}`, true},
+		{`{"field":"attr.customer","gt":"2024-01-01"}`, false}, // not a date
+		{`{"and":[{"field":"source","eq":"contract.pdf"},{"field":"attr.customer","eq":"acme"}]}`, true},
+		{`{"and":[{"field":"source","eq":"contract.pdf"},{"field":"attr.customer","eq":"globex"}]}`, false},
+		{`{"or":[{"field":"attr.customer","eq":"globex"},{"field":"source","eq":"contract.pdf"}]}`, true},
+		{`{"or":[{"field":"attr.customer","eq":"globex"},{"and":[{"field":"source","eq":"contract.pdf"},{"field":"created_at","gte":"2025-01-01"}]}]}`, false},
+	}
+	for _, tc := range tests {
+		if got := parseTestFilter(t, tc.filter).Match(ch); got != tc.want {
+			t.Errorf("%s: expected %v, got %v", tc.filter, tc.want, got)
+		}
+	}
+
+	var nilFilter *Filter
+	if !nilFilter.Match(ch) {
+		t.Errorf("nil filter should match everything")
+	}
+}
+
+func TestFilter_ValidateErrors(t *testing.T) {
+	tests := []struct {
+		filter, want string
+	}{
+		{`{}`, "exactly one of and, or, field"},
+		{`{"field":"source","eq":"a","and":[{"field":"source","eq":"b"}]}`, "exactly one of and, or, field"},
+		{`{"field":"colour","eq":"red"}`, `unknown field "colour"`},
+		{`{"field":"attr.","eq":"x"}`, `unknown field "attr."`},
+		{`{"field":"source"}`, "need exactly one of eq, in, or a range"},
+		{`{"field":"source","eq":"a","in":["b"]}`, "need exactly one of eq, in, or a range"},
+		{`{"field":"created_at","gte":"yesterday"}`, `gte "yesterday" is not a date`},
+		{`{"or":[{"field":"source","eq":"a"},{"field":"nope","eq":"b"}]}`, `or[1]: filter: unknown field "nope"`},
+	}
+	for _, tc := range tests {
+		var f Filter
+		if err := json.Unmarshal([]byte(tc.filter), &f); err != nil {
+			t.Fatalf("invalid filter json %s: %v", tc.filter, err)
+		}
+		err := f.Validate()
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: expected error containing %q, got %v", tc.filter, tc.want, err)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package rag
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Retriever names, as reported in SearchResult.Ranks.
+const (
+	RetrieverVector  = "vector"
+	RetrieverKeyword = "keyword"
+)
+
+// FusionMethod is how Fuse combines ranked lists.
+type FusionMethod string
+
+const (
+	// FusionRRF is reciprocal rank fusion: a chunk scores
+	// alpha/(k+vector rank) + (1-alpha)/(k+keyword rank). It only looks at
+	// ranks, so it does not care that cosine and BM25 scores live on
+	// different scales.
+	FusionRRF FusionMethod = "rrf"
+	// FusionWeighted rescales each list's scores to [0, 1] (min-max) and
+	// adds them as alpha*vector + (1-alpha)*keyword.
+	FusionWeighted FusionMethod = "weighted"
+)
+
+const (
+	defaultFusionAlpha = 0.5
+	defaultRRFK        = 60
+)
+
+// FusionOptions tunes Fuse. Zero values use the defaults.
+type FusionOptions struct {
+	// Method defaults to FusionRRF.
+	Method FusionMethod
+	// Alpha is the weight of the vector list, from 0 (keyword only) to 1
+	// (vector only). Nil means 0.5.
+	Alpha *float64
+	// K dampens the advantage of the very first ranks in RRF. Default 60.
+	K int
+}
+
+// Validate reports an unknown method or an alpha outside [0, 1].
+func (o FusionOptions) Validate() error {
+	switch o.Method {
+	case "", FusionRRF, FusionWeighted:
+	default:
+		return fmt.Errorf("unknown fusion %q (want %s or %s)", o.Method, FusionRRF, FusionWeighted)
+	}
+	if o.Alpha != nil && (*o.Alpha < 0 || *o.Alpha > 1) {
+		return fmt.Errorf("alpha %v is outside [0, 1]", *o.Alpha)
+	}
+	return nil
+}
+
+// Fuse merges the results of vector and keyword search for one query into
+// a single ranking and returns the best topK. Chunks are matched by ID.
+// Each result's Ranks records its 1-based rank in every list it appeared
+// in, and its Score is the fused score. Equal scores keep the order in
+// which chunks were first seen, vector results first.
+func Fuse(vector, keyword []SearchResult, topK int, opts FusionOptions) []SearchResult {
+	if topK <= 0 {
+		return []SearchResult{}
+	}
+	alpha := defaultFusionAlpha
+	if opts.Alpha != nil {
+		alpha = *opts.Alpha
+	}
+	k := opts.K
+	if k <= 0 {
+		k = defaultRRFK
+	}
+
+	var fused []SearchResult
+	byID := map[string]int{}
+	add := func(list []SearchResult, retriever string, weight float64) {
+		norm := minMax(list)
+		for i, r := range list {
+			var score float64
+			if opts.Method == FusionWeighted {
+				score = weight * norm(r.Score)
+			} else {
+				score = weight / float64(k+i+1)
+			}
+			j, ok := byID[r.Chunk.ID]
+			if !ok {
+				j = len(fused)
+				byID[r.Chunk.ID] = j
+				fused = append(fused, SearchResult{Chunk: r.Chunk, Collection: r.Collection, Ranks: map[string]int{}})
+			}
+			fused[j].Score += score
+			fused[j].Ranks[retriever] = i + 1
+		}
+	}
+	add(vector, RetrieverVector, alpha)
+	add(keyword, RetrieverKeyword, 1-alpha)
+
+	sort.SliceStable(fused, func(i, j int) bool { return fused[i].Score > fused[j].Score })
+	if len(fused) > topK {
+		fused = fused[:topK]
+	}
+	if fused == nil {
+		fused = []SearchResult{}
+	}
+	return fused
+}
+
+// minMax returns a function rescaling scores from list to [0, 1]. When all
+// scores are equal every result maps to 1.
+func minMax(list []SearchResult) func(float64) float64 {
+	if len(list) == 0 {
+		return func(float64) float64 { return 0 }
+	}
+	lo, hi := list[0].Score, list[0].Score
+	for _, r := range list {
+		lo, hi = min(lo, r.Score), max(hi, r.Score)
+	}
+	if hi == lo {
+		return func(float64) float64 { return 1 }
+	}
+	return func(s float64) float64 { return (s - lo) / (hi - lo) }
+}
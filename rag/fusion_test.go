@@ -0,0 +1,90 @@
+package rag
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func results(idsAndScores ...any) []SearchResult {
+	var out []SearchResult
+	for i := 0; i < len(idsAndScores); i += 2 {
+		out = append(out, SearchResult{Chunk: Chunk{ID: idsAndScores[i].(string)}, Score: idsAndScores[i+1].(float64)})
+	}
+	return out
+}
+
+func fusedIDs(rs []SearchResult) string {
+	ids := make([]string, len(rs))
+	for i, r := range rs {
+		ids[i] = r.Chunk.ID
+	}
+	return fmt.Sprint(ids)
+}
+
+func TestFuse_RRF(t *testing.T) {
+	vector := results("a", 0.9, "b", 0.8, "c", 0.7)
+	keyword := results("c", 12.0, "d", 3.0)
+
+	got := Fuse(vector, keyword, 10, FusionOptions{})
+	// c is in both lists and wins; a, the vector top hit, comes next. b
+	// and d are both second in one list and tie, vector results first.
+	if fusedIDs(got) != "[c a b d]" {
+		t.Fatalf("unexpected order %s", fusedIDs(got))
+	}
+	if got[0].Ranks[RetrieverVector] != 3 || got[0].Ranks[RetrieverKeyword] != 1 {
+		t.Fatalf("expected c ranked 3rd by vector and 1st by keyword, got %v", got[0].Ranks)
+	}
+	if want := 0.5/63 + 0.5/61; math.Abs(got[0].Score-want) > 1e-12 {
+		t.Fatalf("expected RRF score %v, got %v", want, got[0].Score)
+	}
+	if _, ok := got[1].Ranks[RetrieverKeyword]; ok {
+		t.Fatalf("a was not a keyword hit, got ranks %v", got[1].Ranks)
+	}
+}
+
+func TestFuse_Alpha(t *testing.T) {
+	vector := results("a", 0.9, "b", 0.8)
+	keyword := results("b", 5.0, "a", 1.0)
+
+	one, zero := 1.0, 0.0
+	for _, method := range []FusionMethod{FusionRRF, FusionWeighted} {
+		if got := Fuse(vector, keyword, 2, FusionOptions{Method: method, Alpha: &one}); fusedIDs(got) != "[a b]" {
+			t.Errorf("%s alpha=1: expected vector order [a b], got %s", method, fusedIDs(got))
+		}
+		if got := Fuse(vector, keyword, 2, FusionOptions{Method: method, Alpha: &zero}); fusedIDs(got) != "[b a]" {
+			t.Errorf("%s alpha=0: expected keyword order [b a], got %s", method, fusedIDs(got))
+		}
+	}
+}
+
+func TestFuse_Weighted(t *testing.T) {
+	vector := results("a", 0.9, "b", 0.5, "c", 0.1)
+	keyword := results("c", 8.0, "a", 2.0)
+
+	got := Fuse(vector, keyword, 3, FusionOptions{Method: FusionWeighted})
+	// a: 0.5*1 + 0.5*0 = 0.5; c: 0.5*0 + 0.5*1 = 0.5; b: 0.5*0.5 = 0.25.
+	if fusedIDs(got) != "[a c b]" {
+		t.Fatalf("expected [a c b], got %s", fusedIDs(got))
+	}
+	if math.Abs(got[2].Score-0.25) > 1e-12 {
+		t.Fatalf("expected b to score 0.25, got %v", got[2].Score)
+	}
+}
+
+func TestFuse_Edges(t *testing.T) {
+	if got := Fuse(nil, nil, 3, FusionOptions{}); got == nil || len(got) != 0 {
+		t.Fatalf("expected an empty non-nil slice, got %#v", got)
+	}
+	if got := Fuse(results("a", 1.0, "b", 0.5), nil, 1, FusionOptions{}); fusedIDs(got) != "[a]" {
+		t.Fatalf("expected topK to cut to [a], got %s", fusedIDs(got))
+	}
+
+	bad := 1.5
+	if err := (FusionOptions{Alpha: &bad}).Validate(); err == nil {
+		t.Fatal("expected alpha 1.5 to be rejected")
+	}
+	if err := (FusionOptions{Method: "max"}).Validate(); err == nil {
+		t.Fatal("expected an unknown method to be rejected")
+	}
+}
@@ -0,0 +1,369 @@
+package rag
+
+import (
+	"container/heap"
+	"math"
+	"math/rand/v2"
+	"sort"
+	"sync"
+)
+
+const (
+	defaultHNSWM              = 16
+	defaultHNSWEfConstruction = 200
+	defaultHNSWEfSearch       = 64
+)
+
+// HNSWOptions tunes an HNSWIndex. Zero values use the defaults.
+type HNSWOptions struct {
+	// M is how many neighbours a node keeps per layer (2*M on the bottom
+	// layer). Higher M means better recall and more memory. Default 16.
+	M int
+	// EfConstruction is the candidate list size while inserting. Higher
+	// values build a better graph, more slowly. Default 200.
+	EfConstruction int
+	// EfSearch is the candidate list size while searching; it is raised to
+	// topK when smaller. Higher values trade speed for recall. Default 64.
+	EfSearch int
+}
+
+// HNSWIndex is an approximate nearest-neighbour index over chunk
+// embeddings (Hierarchical Navigable Small World graphs, Malkov & Yashunin).
+// Search visits a small part of the graph instead of every chunk, so it
+// scales to large stores at the cost of occasionally missing a true
+// neighbour. Scores are cosine similarities, as in InMemoryStore.Search.
+//
+// Inserts are incremental. Deletes only mark nodes as tombstones, which
+// keep routing searches but are never returned; once tombstones outnumber
+// live nodes the graph is rebuilt from the live ones. Inserting a chunk ID
+// that is already indexed replaces the earlier chunk.
+//
+// An HNSWIndex is safe for concurrent use.
+type HNSWIndex struct {
+	mu sync.RWMutex
+
+	m, mMax0       int
+	efConstruction int
+	efSearch       int
+	levelMult      float64
+	rng            *rand.Rand
+
+	nodes    []*hnswNode
+	byID     map[string]int
+	entry    int // -1 while empty
+	maxLevel int
+	deleted  int
+}
+
+type hnswNode struct {
+	chunk   Chunk
+	vec     []float64 // unit length, or all zeros
+	friends [][]int   // neighbour node indices per layer
+	deleted bool
+}
+
+// NewHNSWIndex returns an empty index.
+func NewHNSWIndex(opts HNSWOptions) *HNSWIndex {
+	if opts.M <= 1 {
+		opts.M = defaultHNSWM
+	}
+	if opts.EfConstruction <= 0 {
+		opts.EfConstruction = defaultHNSWEfConstruction
+	}
+	if opts.EfSearch <= 0 {
+		opts.EfSearch = defaultHNSWEfSearch
+	}
+	return &HNSWIndex{
+		m:              opts.M,
+		mMax0:          2 * opts.M,
+		efConstruction: max(opts.EfConstruction, opts.M),
+		efSearch:       opts.EfSearch,
+		levelMult:      1 / math.Log(float64(opts.M)),
+		// A fixed seed keeps graphs, and so results, reproducible.
+		rng:   rand.New(rand.NewPCG(1, 2)),
+		byID:  map[string]int{},
+		entry: -1,
+	}
+}
+
+// SetEfSearch changes the search candidate list size (see HNSWOptions)
+// without rebuilding the graph.
+func (h *HNSWIndex) SetEfSearch(ef int) {
+	if ef <= 0 {
+		ef = defaultHNSWEfSearch
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.efSearch = ef
+}
+
+// Len returns the number of live (not deleted) chunks.
+func (h *HNSWIndex) Len() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.nodes) - h.deleted
+}
+
+// Insert adds chunks to the graph.
+func (h *HNSWIndex) Insert(chunks ...Chunk) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, ch := range chunks {
+		h.insert(ch)
+	}
+}
+
+// Delete tombstones the chunks with the given IDs and returns how many
+// were indexed.
+func (h *HNSWIndex) Delete(ids ...string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	n := 0
+	for _, id := range ids {
+		if h.remove(id) {
+			n++
+		}
+	}
+	if h.deleted > 0 && h.deleted >= len(h.nodes)-h.deleted {
+		h.rebuild()
+	}
+	return n
+}
+
+// Reset drops every node.
+func (h *HNSWIndex) Reset() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.nodes, h.byID = nil, map[string]int{}
+	h.entry, h.maxLevel, h.deleted = -1, 0, 0
+}
+
+// Search returns the topK indexed chunks closest to the query, best first.
+func (h *HNSWIndex) Search(queryEmbedding []float64, topK int) []SearchResult {
+	return h.SearchFunc(queryEmbedding, topK, nil)
+}
+
+// SearchFunc is Search restricted to chunks for which keep returns true
+// (nil keeps all). Other chunks still route the search but never take a
+// place in the results, so topK is filled with kept chunks whenever there
+// are enough of them.
+func (h *HNSWIndex) SearchFunc(queryEmbedding []float64, topK int, keep func(Chunk) bool) []SearchResult {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	live := len(h.nodes) - h.deleted
+	if topK <= 0 || live == 0 {
+		return []SearchResult{}
+	}
+	topK = min(topK, live)
+	q := normalized(queryEmbedding)
+
+	ep := h.greedyDescend(q, h.entry, h.maxLevel, 0)
+	// Tombstones and filtered-out chunks take up room in the candidate
+	// list; widen it until enough results come back (ef == len(nodes) is
+	// an exhaustive search).
+	for ef := max(h.efSearch, topK); ; ef *= 2 {
+		found := h.searchLayer(q, ep, ef, 0)
+		results := make([]SearchResult, 0, topK)
+		for _, c := range found {
+			if n := h.nodes[c.node]; !n.deleted && (keep == nil || keep(n.chunk)) {
+				// Report the same score as exact search, free of rounding
+				// from normalization.
+				results = append(results, SearchResult{Chunk: n.chunk, Score: cosine(queryEmbedding, n.chunk.Embedding)})
+			}
+			if len(results) == topK {
+				return results
+			}
+		}
+		if ef >= len(h.nodes) {
+			return results
+		}
+	}
+}
+
+func (h *HNSWIndex) insert(ch Chunk) {
+	h.remove(ch.ID)
+
+	level := int(math.Floor(-math.Log(1-h.rng.Float64()) * h.levelMult))
+	id := len(h.nodes)
+	node := &hnswNode{chunk: ch, vec: normalized(ch.Embedding), friends: make([][]int, level+1)}
+	h.nodes = append(h.nodes, node)
+	h.byID[ch.ID] = id
+
+	if h.entry < 0 {
+		h.entry, h.maxLevel = id, level
+		return
+	}
+
+	ep := h.greedyDescend(node.vec, h.entry, h.maxLevel, level+1)
+	for l := min(level, h.maxLevel); l >= 0; l-- {
+		candidates := h.searchLayer(node.vec, ep, h.efConstruction, l)
+		node.friends[l] = h.selectNeighbors(candidates, h.m)
+		for _, f := range node.friends[l] {
+			h.link(f, id, l)
+		}
+		ep = candidates[0].node
+	}
+	if level > h.maxLevel {
+		h.entry, h.maxLevel = id, level
+	}
+}
+
+// remove tombstones the node holding id, if any.
+func (h *HNSWIndex) remove(id string) bool {
+	i, ok := h.byID[id]
+	if !ok {
+		return false
+	}
+	delete(h.byID, id)
+	h.nodes[i].deleted = true
+	h.nodes[i].chunk = Chunk{} // release content; vec still routes searches
+	h.deleted++
+	return true
+}
+
+// rebuild re-inserts the live nodes into a fresh graph, dropping tombstones.
+func (h *HNSWIndex) rebuild() {
+	old := h.nodes
+	h.nodes, h.byID = nil, map[string]int{}
+	h.entry, h.maxLevel, h.deleted = -1, 0, 0
+	for _, n := range old {
+		if !n.deleted {
+			h.insert(n.chunk)
+		}
+	}
+}
+
+// link adds to as a neighbour of from on layer l, pruning from's list back
+// to capacity with the selection heuristic.
+func (h *HNSWIndex) link(from, to, l int) {
+	n := h.nodes[from]
+	n.friends[l] = append(n.friends[l], to)
+	limit := h.m
+	if l == 0 {
+		limit = h.mMax0
+	}
+	if len(n.friends[l]) <= limit {
+		return
+	}
+	candidates := make([]hnswCandidate, len(n.friends[l]))
+	for i, f := range n.friends[l] {
+		candidates[i] = hnswCandidate{node: f, dist: distance(n.vec, h.nodes[f].vec)}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].dist < candidates[j].dist })
+	n.friends[l] = h.selectNeighbors(candidates, limit)
+}
+
+// selectNeighbors picks up to m of the candidates (sorted nearest first).
+// A candidate is preferred when it is closer to the new node than to any
+// neighbour already picked, which keeps links pointing in different
+// directions; the rest fill remaining slots.
+func (h *HNSWIndex) selectNeighbors(candidates []hnswCandidate, m int) []int {
+	picked := make([]int, 0, m)
+	var skipped []int
+	for _, c := range candidates {
+		if len(picked) == m {
+			break
+		}
+		diverse := true
+		for _, p := range picked {
+			if distance(h.nodes[c.node].vec, h.nodes[p].vec) < c.dist {
+				diverse = false
+				break
+			}
+		}
+		if diverse {
+			picked = append(picked, c.node)
+		} else {
+			skipped = append(skipped, c.node)
+		}
+	}
+	for _, s := range skipped {
+		if len(picked) == m {
+			break
+		}
+		picked = append(picked, s)
+	}
+	return picked
+}
+
+// greedyDescend walks from ep down to layer stop, moving to the closest
+// neighbour on each layer above it.
+func (h *HNSWIndex) greedyDescend(q []float64, ep, top, stop int) int {
+	best := distance(q, h.nodes[ep].vec)
+	for l := top; l >= stop; l-- {
+		for changed := true; changed; {
+			changed = false
+			for _, f := range h.nodes[ep].friends[l] {
+				if d := distance(q, h.nodes[f].vec); d < best {
+					ep, best, changed = f, d, true
+				}
+			}
+		}
+	}
+	return ep
+}
+
+// searchLayer returns up to ef nodes closest to q on layer l, nearest first.
+func (h *HNSWIndex) searchLayer(q []float64, ep, ef, l int) []hnswCandidate {
+	start := hnswCandidate{node: ep, dist: distance(q, h.nodes[ep].vec)}
+	visited := map[int]bool{ep: true}
+	candidates := &candidateHeap{items: []hnswCandidate{start}}            // nearest on top
+	found := &candidateHeap{items: []hnswCandidate{start}, farthest: true} // farthest on top
+
+	for candidates.Len() > 0 {
+		c := heap.Pop(candidates).(hnswCandidate)
+		if found.Len() >= ef && c.dist > found.items[0].dist {
+			break
+		}
+		for _, f := range h.nodes[c.node].friends[l] {
+			if visited[f] {
+				continue
+			}
+			visited[f] = true
+			d := distance(q, h.nodes[f].vec)
+			if found.Len() < ef || d < found.items[0].dist {
+				heap.Push(candidates, hnswCandidate{node: f, dist: d})
+				heap.Push(found, hnswCandidate{node: f, dist: d})
+				if found.Len() > ef {
+					heap.Pop(found)
+				}
+			}
+		}
+	}
+
+	out := found.items
+	sort.Slice(out, func(i, j int) bool { return out[i].dist < out[j].dist })
+	return out
+}
+
+// distance is the cosine distance between two normalized vectors.
+func distance(a, b []float64) float64 {
+	return 1 - dot(a, b)
+}
+
+type hnswCandidate struct {
+	node int
+	dist float64
+}
+
+// candidateHeap is a min-heap by distance, or a max-heap when farthest is set.
+type candidateHeap struct {
+	items    []hnswCandidate
+	farthest bool
+}
+
+func (c *candidateHeap) Len() int { return len(c.items) }
+func (c *candidateHeap) Less(i, j int) bool {
+	if c.farthest {
+		return c.items[i].dist > c.items[j].dist
+	}
+	return c.items[i].dist < c.items[j].dist
+}
+func (c *candidateHeap) Swap(i, j int) { c.items[i], c.items[j] = c.items[j], c.items[i] }
+func (c *candidateHeap) Push(x any)    { c.items = append(c.items, x.(hnswCandidate)) }
+func (c *candidateHeap) Pop() any {
+	last := c.items[len(c.items)-1]
+	c.items = c.items[:len(c.items)-1]
+	return last
+}
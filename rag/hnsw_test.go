@@ -0,0 +1,180 @@
+package rag
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"testing"
+)
+
+func randomChunks(rng *rand.Rand, n, dim int) []Chunk {
+	chunks := make([]Chunk, n)
+	for i := range chunks {
+		chunks[i] = Chunk{ID: fmt.Sprint(i), Embedding: randomVector(rng, dim)}
+	}
+	return chunks
+}
+
+func randomVector(rng *rand.Rand, dim int) []float64 {
+	v := make([]float64, dim)
+	for i := range v {
+		v[i] = rng.NormFloat64()
+	}
+	return v
+}
+
+// exactTopK returns the IDs of the true top-k chunks for every query.
+func exactTopK(exact *InMemoryStore, queries [][]float64, k int) []map[string]bool {
+	truth := make([]map[string]bool, len(queries))
+	for i, q := range queries {
+		truth[i] = map[string]bool{}
+		for _, r := range exact.Search(q, k) {
+			truth[i][r.Chunk.ID] = true
+		}
+	}
+	return truth
+}
+
+// recallAt returns the fraction of the true top-k that the index found,
+// averaged over the queries.
+func recallAt(index *HNSWIndex, queries [][]float64, truth []map[string]bool, k int) float64 {
+	hits := 0
+	for i, q := range queries {
+		for _, r := range index.Search(q, k) {
+			if truth[i][r.Chunk.ID] {
+				hits++
+			}
+		}
+	}
+	return float64(hits) / float64(len(queries)*k)
+}
+
+func TestHNSWIndex_Recall(t *testing.T) {
+	rng := rand.New(rand.NewPCG(7, 7))
+	chunks := randomChunks(rng, 2000, 32)
+	exact := NewInMemoryStore()
+	exact.Add(chunks...)
+	index := NewHNSWIndex(HNSWOptions{})
+	index.Insert(chunks...)
+
+	queries := make([][]float64, 50)
+	for i := range queries {
+		queries[i] = randomVector(rng, 32)
+	}
+	if recall := recallAt(index, queries, exactTopK(exact, queries, 10), 10); recall < 0.9 {
+		t.Fatalf("expected recall@10 >= 0.9, got %.3f", recall)
+	}
+}
+
+func TestHNSWIndex_SearchOrderAndScores(t *testing.T) {
+	index := NewHNSWIndex(HNSWOptions{})
+	index.Insert(
+		Chunk{ID: "x", Embedding: []float64{1, 0}},
+		Chunk{ID: "y", Embedding: []float64{0, 1}},
+		Chunk{ID: "xy", Embedding: []float64{1, 1}},
+	)
+
+	res := index.Search([]float64{0.9, 0.1}, 5)
+	if len(res) != 3 || res[0].Chunk.ID != "x" || res[1].Chunk.ID != "xy" || res[2].Chunk.ID != "y" {
+		t.Fatalf("unexpected results: %+v", res)
+	}
+	if want := cosine([]float64{0.9, 0.1}, []float64{1, 0}); res[0].Score != want {
+		t.Fatalf("expected score %f, got %f", want, res[0].Score)
+	}
+}
+
+func TestHNSWIndex_DeleteTombstones(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 1))
+	chunks := randomChunks(rng, 300, 8)
+	index := NewHNSWIndex(HNSWOptions{})
+	index.Insert(chunks...)
+
+	var ids []string
+	for i := 0; i < 100; i++ {
+		ids = append(ids, fmt.Sprint(i))
+	}
+	if n := index.Delete(ids...); n != 100 {
+		t.Fatalf("expected 100 deleted, got %d", n)
+	}
+	if n := index.Delete("0"); n != 0 {
+		t.Fatalf("deleting twice: expected 0, got %d", n)
+	}
+	if index.Len() != 200 || index.deleted != 100 {
+		t.Fatalf("expected 200 live nodes and 100 tombstones, got %d and %d", index.Len(), index.deleted)
+	}
+
+	// Deleted chunks never come back, even when they are the closest.
+	res := index.Search(chunks[5].Embedding, 200)
+	if len(res) != 200 {
+		t.Fatalf("expected all 200 live chunks, got %d", len(res))
+	}
+	for _, r := range res {
+		var n int
+		fmt.Sscan(r.Chunk.ID, &n)
+		if n < 100 {
+			t.Fatalf("deleted chunk %s returned", r.Chunk.ID)
+		}
+	}
+}
+
+func TestHNSWIndex_RebuildsWhenMostlyTombstones(t *testing.T) {
+	rng := rand.New(rand.NewPCG(2, 2))
+	chunks := randomChunks(rng, 100, 8)
+	index := NewHNSWIndex(HNSWOptions{})
+	index.Insert(chunks...)
+
+	var ids []string
+	for _, ch := range chunks[:60] {
+		ids = append(ids, ch.ID)
+	}
+	index.Delete(ids...)
+
+	if len(index.nodes) != 40 || index.deleted != 0 {
+		t.Fatalf("expected a rebuilt graph of 40 nodes, got %d nodes and %d tombstones", len(index.nodes), index.deleted)
+	}
+	if res := index.Search(chunks[70].Embedding, 1); len(res) != 1 || res[0].Chunk.ID != chunks[70].ID {
+		t.Fatalf("expected to find chunk %s after rebuild, got %+v", chunks[70].ID, res)
+	}
+}
+
+func TestHNSWIndex_ReinsertReplaces(t *testing.T) {
+	index := NewHNSWIndex(HNSWOptions{})
+	index.Insert(Chunk{ID: "a", Content: "old", Embedding: []float64{1, 0}})
+	index.Insert(Chunk{ID: "a", Content: "new", Embedding: []float64{0, 1}})
+
+	res := index.Search([]float64{0, 1}, 10)
+	if len(res) != 1 || res[0].Chunk.Content != "new" {
+		t.Fatalf("expected only the new chunk, got %+v", res)
+	}
+}
+
+// BenchmarkHNSWIndex_Search compares HNSW with exact search on the same
+// data and reports recall@10 next to the timings.
+func BenchmarkHNSWIndex_Search(b *testing.B) {
+	const n, dim, k = 10000, 64, 10
+	rng := rand.New(rand.NewPCG(3, 3))
+	chunks := randomChunks(rng, n, dim)
+	queries := make([][]float64, 100)
+	for i := range queries {
+		queries[i] = randomVector(rng, dim)
+	}
+	exact := NewInMemoryStore()
+	exact.Add(chunks...)
+	truth := exactTopK(exact, queries, k)
+
+	b.Run("exact", func(b *testing.B) {
+		for i := 0; b.Loop(); i++ {
+			exact.Search(queries[i%len(queries)], k)
+		}
+	})
+	index := NewHNSWIndex(HNSWOptions{})
+	index.Insert(chunks...)
+	for _, ef := range []int{16, 64, 256} {
+		index.SetEfSearch(ef)
+		b.Run(fmt.Sprintf("hnsw/ef=%d", ef), func(b *testing.B) {
+			for i := 0; b.Loop(); i++ {
+				index.Search(queries[i%len(queries)], k)
+			}
+			b.ReportMetric(recallAt(index, queries, truth, k), "recall@10")
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package rag
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"strings"
+)
+
+const defaultLocalDim = 1024
+
+// Feature weights, relative to a word.
+const (
+	bigramWeight = 0.75
+	ngramWeight  = 0.25
+)
+
+// LocalOptions configures a LocalEmbedder.
+type LocalOptions struct {
+	// Dim is the length of the vectors (default 1024). More dimensions
+	// mean fewer features sharing a slot.
+	Dim int
+	// Corpus, when given, is a sample of the texts to be embedded (one
+	// text per chunk or paragraph). Features are then weighted by inverse
+	// document frequency, so words that appear everywhere count for
+	// little. Without it there is no IDF weighting at all, only a short
+	// list of English stop words left out.
+	Corpus []string
+}
+
+// LocalEmbedder embeds text without any service or model file: words,
+// word pairs and character 3- to 5-grams (which catch shared word stems
+// and typos) are hashed into a fixed number of dimensions and weighted by
+// TF-IDF. Texts sharing vocabulary come out close; there is no notion of
+// synonyms.
+//
+// It is deterministic and safe for concurrent use. Its Model names the
+// dimension and the IDF table, so vectors from differently configured
+// embedders are never compared.
+type LocalEmbedder struct {
+	dim   int
+	idf   []float64 // by slot; nil means no weighting
+	model string
+}
+
+// NewLocalEmbedder builds an embedder, fitting IDF to opts.Corpus.
+func NewLocalEmbedder(opts LocalOptions) *LocalEmbedder {
+	dim := opts.Dim
+	if dim <= 0 {
+		dim = defaultLocalDim
+	}
+	e := &LocalEmbedder{dim: dim, model: fmt.Sprintf("local-%d", dim)}
+	if len(opts.Corpus) == 0 {
+		return e
+	}
+
+	// Slot document frequencies stand in for feature ones: with signed
+	// hashing the collisions are rare enough not to matter.
+	df := make([]int, dim)
+	for _, text := range opts.Corpus {
+		for slot := range e.features(text, false) {
+			df[slot]++
+		}
+	}
+	e.idf = make([]float64, dim)
+	h := fnv.New64a()
+	n := float64(len(opts.Corpus))
+	for slot, d := range df {
+		e.idf[slot] = math.Log((1+n)/(1+float64(d))) + 1
+		fmt.Fprintf(h, "%d,", d)
+	}
+	e.model = fmt.Sprintf("local-%d-idf-%08x", dim, uint32(h.Sum64()))
+	return e
+}
+
+func (e *LocalEmbedder) Model() string { return e.model }
+
+// Embed returns an L2-normalized vector. A text with no words gives the
+// zero vector, which matches nothing.
+func (e *LocalEmbedder) Embed(ctx context.Context, text string) ([]float64, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	vec := make([]float64, e.dim)
+	for slot, tf := range e.features(text, e.idf == nil) {
+		w := math.Copysign(math.Log1p(math.Abs(tf)), tf) // sublinear TF
+		if e.idf != nil {
+			w *= e.idf[slot]
+		}
+		vec[slot] = w
+	}
+	return normalized(vec), nil
+}
+
+// features hashes the features of text into slots, summing their signed
+// weights.
+func (e *LocalEmbedder) features(text string, skipStopWords bool) map[int]float64 {
+	out := map[int]float64{}
+	add := func(kind, feature string, weight float64) {
+		h := fnv.New64a()
+		h.Write([]byte(kind))
+		h.Write([]byte(feature))
+		sum := h.Sum64()
+		// The top bit picks a sign, so colliding features tend to
+		// cancel out instead of piling up.
+		if sum>>63 == 1 {
+			weight = -weight
+		}
+		out[int(sum%uint64(e.dim))] += weight
+	}
+
+	var prev string
+	for _, term := range Terms(text) {
+		if skipStopWords && stopWords[term] {
+			prev = ""
+			continue
+		}
+		add("w:", term, 1)
+		if prev != "" {
+			add("b:", prev+" "+term, bigramWeight)
+		}
+		prev = term
+
+		padded := []rune("<" + term + ">")
+		for n := 3; n <= 5; n++ {
+			for i := 0; i+n <= len(padded); i++ {
+				add("c:", string(padded[i:i+n]), ngramWeight)
+			}
+		}
+	}
+	// Drop slots where features cancelled out exactly.
+	for slot, w := range out {
+		if w == 0 {
+			delete(out, slot)
+		}
+	}
+	return out
+}
+
+// stopWords are left out when there is no corpus to learn IDF from. They
+// are stemmed like Terms output.
+var stopWords = func() map[string]bool {
+	words := strings.Fields(`a an and are as at be but by do does for from has have
+		he her his how i if in into is it its me my no not of on or our she so
+		than that the their them then there these they this to too was we were
+		what when where which who why will with you your`)
+	out := make(map[string]bool, len(words))
+	for _, w := range words {
+		out[stem(w)] = true
+	}
+	return out
+}()
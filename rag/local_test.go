@@ -0,0 +1,87 @@
+package rag
+
+import (
+	"context"
+	"math"
+	"testing"
+)
+
+func TestLocalEmbedder_Deterministic(t *testing.T) {
+	e := NewLocalEmbedder(LocalOptions{Dim: 256})
+	v1 := mustEmbed(t, e, "Go is great for AI.")
+	v2 := mustEmbed(t, NewLocalEmbedder(LocalOptions{Dim: 256}), "Go is great for AI.")
+
+	if len(v1) != 256 {
+		t.Fatalf("expected 256 dimensions, got %d", len(v1))
+	}
+	var norm float64
+	for i := range v1 {
+		if v1[i] != v2[i] {
+			t.Fatalf("embeddings differ at index %d: %v vs %v", i, v1[i], v2[i])
+		}
+		norm += v1[i] * v1[i]
+	}
+	if math.Abs(norm-1) > 1e-9 {
+		t.Fatalf("expected a unit vector, got norm² %v", norm)
+	}
+	if e.Model() != "local-256" {
+		t.Fatalf("unexpected model %q", e.Model())
+	}
+}
+
+func TestLocalEmbedder_Similarity(t *testing.T) {
+	e := NewLocalEmbedder(LocalOptions{})
+	query := mustEmbed(t, e, "How do I reset my password?")
+	related := mustEmbed(t, e, "To reset a forgotten password, open the account settings.")
+	typo := mustEmbed(t, e, "pasword resetting")
+	unrelated := mustEmbed(t, e, "The cafeteria serves lunch from noon until two.")
+
+	if cosine(query, related) <= cosine(query, unrelated) {
+		t.Fatalf("expected the related text closer: %v vs %v", cosine(query, related), cosine(query, unrelated))
+	}
+	if cosine(query, typo) <= cosine(query, unrelated) {
+		t.Fatalf("expected character n-grams to match a typo: %v vs %v", cosine(query, typo), cosine(query, unrelated))
+	}
+	// Sharing only stop words is not similarity.
+	if s := cosine(mustEmbed(t, e, "it is the one"), mustEmbed(t, e, "it is the other")); s > 0.9 {
+		t.Fatalf("expected stop words ignored, got %v", s)
+	}
+}
+
+func TestLocalEmbedder_IDF(t *testing.T) {
+	corpus := []string{
+		"The vacation policy grants 25 days of leave.",
+		"The expense policy covers travel and meals.",
+		"The security policy requires badge access.",
+		"Parking is free for employees.",
+	}
+	e := NewLocalEmbedder(LocalOptions{Corpus: corpus})
+	if e.Model() == "local-1024" || e.Model() == NewLocalEmbedder(LocalOptions{Corpus: corpus[:2]}).Model() {
+		t.Fatalf("expected the model to identify the IDF table, got %q", e.Model())
+	}
+
+	// "policy" is everywhere, "vacation" is not: a query for vacation
+	// policy finds the vacation text over another policy.
+	query := mustEmbed(t, e, "vacation policy")
+	vacation := mustEmbed(t, e, corpus[0])
+	security := mustEmbed(t, e, corpus[2])
+	if cosine(query, vacation) <= cosine(query, security) {
+		t.Fatalf("expected the rare word to dominate: %v vs %v", cosine(query, vacation), cosine(query, security))
+	}
+}
+
+func TestLocalEmbedder_Empty(t *testing.T) {
+	e := NewLocalEmbedder(LocalOptions{Dim: 8})
+	v := mustEmbed(t, e, "?!")
+	for _, x := range v {
+		if x != 0 {
+			t.Fatalf("expected the zero vector for a text without words, got %v", v)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := e.Embed(ctx, "hello"); err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
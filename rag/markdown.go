@@ -0,0 +1,205 @@
+package rag
+
+import (
+	"strings"
+)
+
+const defaultMarkdownChars = 1500
+
+// MetaHeadingPath is the metadata key holding the headings a Markdown chunk
+// lives under, outermost first, e.g. "Setup > Docker".
+const MetaHeadingPath = "heading_path"
+
+// MarkdownChunker splits Markdown along its heading hierarchy: every section
+// (a heading and the content up to the next heading) becomes its own segments,
+// so the tail of one section is never glued to the next heading. Sections
+// longer than MaxChars are cut between blocks (paragraphs, lists, tables,
+// fenced code); a block itself is never cut, even when it is larger than
+// MaxChars.
+type MarkdownChunker struct {
+	MaxChars int
+}
+
+// mdBlock is a run of Markdown lines that must stay together.
+type mdBlock struct {
+	start, end int
+	level      int // heading level, 0 for non-heading blocks
+	title      string
+}
+
+func (c *MarkdownChunker) Split(text string) []Segment {
+	maxChars := c.MaxChars
+	if maxChars <= 0 {
+		maxChars = defaultMarkdownChars
+	}
+
+	var segments []Segment
+	var headings [6]string
+	segStart, segEnd := -1, -1
+
+	flush := func() {
+		if segStart < 0 {
+			return
+		}
+		seg := segmentAt(text, segStart, segEnd)
+		segStart, segEnd = -1, -1
+		if strings.TrimSpace(seg.Content) == "" {
+			return
+		}
+		if path := headingPath(headings[:]); path != "" {
+			seg.Metadata = map[string]string{MetaHeadingPath: path}
+		}
+		segments = append(segments, seg)
+	}
+
+	onlyHeading := false
+	for _, b := range parseMarkdownBlocks(text) {
+		if b.level > 0 {
+			if onlyHeading {
+				segStart = -1 // the previous heading has no content of its own
+			}
+			flush()
+			headings[b.level-1] = b.title
+			for i := b.level; i < len(headings); i++ {
+				headings[i] = ""
+			}
+			segStart, segEnd = b.start, b.end
+			onlyHeading = true
+			continue
+		}
+		if segStart >= 0 && !onlyHeading && b.end-segStart > maxChars {
+			flush()
+		}
+		if segStart < 0 {
+			segStart = b.start
+		}
+		segEnd = b.end
+		onlyHeading = false
+	}
+	// A trailing heading with nothing under it is not worth a chunk.
+	if !onlyHeading {
+		flush()
+	}
+
+	return segments
+}
+
+func headingPath(headings []string) string {
+	var parts []string
+	for _, h := range headings {
+		if h != "" {
+			parts = append(parts, h)
+		}
+	}
+	return strings.Join(parts, " > ")
+}
+
+// parseMarkdownBlocks splits text into headings and blank-line separated
+// blocks. Fenced code blocks are single blocks, blank lines included.
+func parseMarkdownBlocks(text string) []mdBlock {
+	var blocks []mdBlock
+	blockStart := -1
+	fence := "" // opening fence marker while inside a code block
+
+	closeBlock := func(end int) {
+		if blockStart >= 0 {
+			blocks = append(blocks, mdBlock{start: blockStart, end: end})
+			blockStart = -1
+		}
+	}
+
+	for pos := 0; pos < len(text); {
+		lineEnd := strings.IndexByte(text[pos:], '\n')
+		next := len(text)
+		if lineEnd >= 0 {
+			next = pos + lineEnd + 1
+			lineEnd = pos + lineEnd
+		} else {
+			lineEnd = len(text)
+		}
+		line := strings.TrimRight(text[pos:lineEnd], "\r")
+
+		switch {
+		case fence != "":
+			if isClosingFence(line, fence) {
+				fence = ""
+				closeBlock(lineEnd)
+			}
+		case fenceMarker(line) != "":
+			closeBlock(pos)
+			fence = fenceMarker(line)
+			blockStart = pos
+		case strings.TrimSpace(line) == "":
+			closeBlock(pos)
+		default:
+			if level, title := parseHeading(line); level > 0 {
+				closeBlock(pos)
+				blocks = append(blocks, mdBlock{start: pos, end: lineEnd, level: level, title: title})
+				break
+			}
+			if blockStart < 0 {
+				blockStart = pos
+			}
+		}
+		pos = next
+	}
+	// An unclosed fence runs to the end of the document.
+	closeBlock(len(text))
+
+	// Trim trailing newlines so segments end on content.
+	for i := range blocks {
+		blocks[i].end = blocks[i].start + len(strings.TrimRight(text[blocks[i].start:blocks[i].end], "\r\n"))
+	}
+	return blocks
+}
+
+// parseHeading recognises ATX headings ("## Title ##") and returns their
+// level and title, or 0.
+func parseHeading(line string) (int, string) {
+	trimmed := strings.TrimLeft(line, " ")
+	if len(line)-len(trimmed) > 3 {
+		return 0, "" // indented code
+	}
+	level := 0
+	for level < len(trimmed) && trimmed[level] == '#' {
+		level++
+	}
+	if level == 0 || level > 6 {
+		return 0, ""
+	}
+	rest := trimmed[level:]
+	if rest != "" && rest[0] != ' ' && rest[0] != '\t' {
+		return 0, "" // "#hashtag"
+	}
+	title := strings.TrimSpace(rest)
+	if stripped := strings.TrimRight(title, "#"); stripped == "" || strings.HasSuffix(stripped, " ") {
+		title = strings.TrimSpace(stripped)
+	}
+	return level, title
+}
+
+// fenceMarker returns the backtick or tilde run opening a fenced code block.
+func fenceMarker(line string) string {
+	trimmed := strings.TrimLeft(line, " ")
+	if len(line)-len(trimmed) > 3 {
+		return ""
+	}
+	for _, ch := range []byte{'`', '~'} {
+		n := 0
+		for n < len(trimmed) && trimmed[n] == ch {
+			n++
+		}
+		if n >= 3 {
+			if ch == '`' && strings.ContainsRune(trimmed[n:], '`') {
+				return "" // inline code such as ```foo```
+			}
+			return trimmed[:n]
+		}
+	}
+	return ""
+}
+
+func isClosingFence(line, fence string) bool {
+	trimmed := strings.TrimSpace(line)
+	return len(trimmed) >= len(fence) && strings.Trim(trimmed, fence[:1]) == ""
+}
@@ -0,0 +1,74 @@
+package rag
+
+import (
+	"strings"
+	"testing"
+)
+
+const sampleMarkdown = "# Setup\n\nIntro paragraph.\n\n## Docker\n\nRun it:\n\n```bash\ndocker compose up\n\n# not a heading\n```\n\n| a | b |\n|---|---|\n| 1 | 2 |\n\n## Cloud Run\n\nDeploy on merge.\n\n# Usage\n### Empty\n## Query\nAsk things.\n"
+
+func TestMarkdownChunker_SectionsAndHeadingPath(t *testing.T) {
+	c := &MarkdownChunker{}
+	segs := c.Split(sampleMarkdown)
+
+	wantPaths := []string{"Setup", "Setup > Docker", "Setup > Cloud Run", "Usage > Query"}
+	if len(segs) != len(wantPaths) {
+		t.Fatalf("expected %d segments, got %d: %+v", len(wantPaths), len(segs), segs)
+	}
+	for i, want := range wantPaths {
+		if got := segs[i].Metadata[MetaHeadingPath]; got != want {
+			t.Fatalf("segment %d: expected heading path %q, got %q", i, want, got)
+		}
+	}
+
+	if !strings.HasPrefix(segs[1].Content, "## Docker") || !strings.HasSuffix(segs[1].Content, "| 1 | 2 |") {
+		t.Fatalf("docker section not kept together: %q", segs[1].Content)
+	}
+	if strings.Contains(segs[0].Content, "Docker") {
+		t.Fatalf("section leaked into the next heading: %q", segs[0].Content)
+	}
+}
+
+func TestMarkdownChunker_NeverSplitsCodeFence(t *testing.T) {
+	code := "```go\n" + strings.Repeat("fmt.Println(\"hi\")\n\n", 20) + "```"
+	text := "# Code\n\nBefore.\n\n" + code + "\n\nAfter."
+	c := &MarkdownChunker{MaxChars: 50}
+	segs := c.Split(text)
+
+	found := false
+	for _, s := range segs {
+		if strings.Contains(s.Content, "```go") {
+			found = true
+			if !strings.HasSuffix(s.Content, "```") || strings.Count(s.Content, "```") != 2 {
+				t.Fatalf("code fence was split: %q", s.Content)
+			}
+		}
+	}
+	if !found {
+		t.Fatalf("code fence missing from segments: %+v", segs)
+	}
+	if len(segs) < 3 {
+		t.Fatalf("expected long section to be cut between blocks, got %d segments", len(segs))
+	}
+}
+
+func TestParseHeading(t *testing.T) {
+	tests := []struct {
+		line  string
+		level int
+		title string
+	}{
+		{"# Title", 1, "Title"},
+		{"### Deep ###", 3, "Deep"},
+		{"#hashtag", 0, ""},
+		{"    # indented code", 0, ""},
+		{"####### too deep", 0, ""},
+		{"## C#", 2, "C#"},
+	}
+	for _, tc := range tests {
+		level, title := parseHeading(tc.line)
+		if level != tc.level || title != tc.title {
+			t.Fatalf("parseHeading(%q) = %d, %q; want %d, %q", tc.line, level, title, tc.level, tc.title)
+		}
+	}
+}
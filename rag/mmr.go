@@ -0,0 +1,60 @@
+package rag
+
+const defaultMMRLambda = 0.5
+
+// MMR re-ranks candidates with Maximal Marginal Relevance and returns
+// topK of them. Each pick maximizes
+//
+//	lambda*relevance - (1-lambda)*max similarity to the chunks already picked
+//
+// so a near-duplicate of an earlier pick loses to a slightly less relevant
+// chunk that says something else. Relevance is the candidate's Score
+// divided by the best one, which keeps cosine scores as they are and puts
+// BM25 and fused scores on the same 0 to 1 footing; similarity is the
+// cosine of the chunk embeddings.
+// lambda 1 keeps the original order, 0 only cares about diversity; values
+// outside [0, 1] use 0.5. Results keep their original Score.
+//
+// Candidates should be ordered best first and number more than topK, or
+// there is nothing to choose from.
+func MMR(candidates []SearchResult, topK int, lambda float64) []SearchResult {
+	if lambda < 0 || lambda > 1 {
+		lambda = defaultMMRLambda
+	}
+	topK = min(max(topK, 0), len(candidates))
+	best := 0.0
+	for _, c := range candidates {
+		best = max(best, c.Score)
+	}
+	relevance := func(score float64) float64 {
+		if best <= 0 {
+			return score
+		}
+		return score / best
+	}
+
+	picked := make([]SearchResult, 0, topK)
+	used := make([]bool, len(candidates))
+	// maxSim[i] is candidate i's highest similarity to a picked chunk.
+	maxSim := make([]float64, len(candidates))
+	for len(picked) < topK {
+		next, nextScore := -1, 0.0
+		for i, c := range candidates {
+			if used[i] {
+				continue
+			}
+			score := lambda*relevance(c.Score) - (1-lambda)*maxSim[i]
+			if next < 0 || score > nextScore {
+				next, nextScore = i, score
+			}
+		}
+		used[next] = true
+		picked = append(picked, candidates[next])
+		for i, c := range candidates {
+			if !used[i] {
+				maxSim[i] = max(maxSim[i], cosine(c.Chunk.Embedding, candidates[next].Chunk.Embedding))
+			}
+		}
+	}
+	return picked
+}
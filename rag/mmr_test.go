@@ -0,0 +1,40 @@
+package rag
+
+import "testing"
+
+func TestMMR_SkipsNearDuplicates(t *testing.T) {
+	candidates := []SearchResult{
+		{Chunk: Chunk{ID: "a", Embedding: []float64{1, 0, 0}}, Score: 0.95},
+		{Chunk: Chunk{ID: "a-dup", Embedding: []float64{0.99, 0.01, 0}}, Score: 0.94},
+		{Chunk: Chunk{ID: "b", Embedding: []float64{0, 1, 0}}, Score: 0.80},
+		{Chunk: Chunk{ID: "c", Embedding: []float64{0, 0, 1}}, Score: 0.60},
+	}
+
+	got := MMR(candidates, 3, 0.5)
+	if fusedIDs(got) != "[a b c]" {
+		t.Fatalf("expected the duplicate skipped, got %s", fusedIDs(got))
+	}
+	if got[1].Score != 0.80 {
+		t.Fatalf("expected original scores kept, got %v", got[1].Score)
+	}
+
+	if got := MMR(candidates, 3, 1); fusedIDs(got) != "[a a-dup b]" {
+		t.Fatalf("lambda 1: expected relevance order, got %s", fusedIDs(got))
+	}
+}
+
+func TestMMR_Bounds(t *testing.T) {
+	candidates := []SearchResult{
+		{Chunk: Chunk{ID: "a", Embedding: []float64{1, 0}}, Score: 1},
+		{Chunk: Chunk{ID: "b", Embedding: []float64{0, 1}}, Score: 0.5},
+	}
+	if got := MMR(candidates, 5, 0.5); len(got) != 2 {
+		t.Fatalf("expected topK capped at 2, got %d", len(got))
+	}
+	if got := MMR(candidates, 0, 0.5); len(got) != 0 {
+		t.Fatalf("expected nothing for topK 0, got %d", len(got))
+	}
+	if got := MMR(nil, 3, 0.5); len(got) != 0 {
+		t.Fatalf("expected nothing from no candidates, got %d", len(got))
+	}
+}
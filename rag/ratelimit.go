@@ -0,0 +1,85 @@
+package rag
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// tokenBucket is a client-side rate limiter: it holds up to perMinute
+// tokens and refills at perMinute per minute. A nil bucket never waits.
+//
+// Callers take what they need up front and wait until the bucket has
+// covered it. A request larger than the whole bucket only waits for a full
+// bucket and leaves the balance negative, delaying the ones after it.
+type tokenBucket struct {
+	mu        sync.Mutex
+	perMinute float64
+	tokens    float64
+	last      time.Time
+	now       func() time.Time
+}
+
+// newTokenBucket returns a full bucket, or nil when perMinute <= 0.
+func newTokenBucket(perMinute int) *tokenBucket {
+	if perMinute <= 0 {
+		return nil
+	}
+	b := &tokenBucket{perMinute: float64(perMinute), tokens: float64(perMinute), now: time.Now}
+	b.last = b.now()
+	return b
+}
+
+// reserve takes n tokens and returns how long the caller must wait before
+// using them.
+func (b *tokenBucket) reserve(n int) time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.now()
+	b.tokens = min(b.perMinute, b.tokens+now.Sub(b.last).Minutes()*b.perMinute)
+	b.last = now
+	var wait time.Duration
+	if short := min(float64(n), b.perMinute) - b.tokens; short > 0 {
+		wait = time.Duration(short / b.perMinute * float64(time.Minute))
+	}
+	b.tokens -= float64(n)
+	return wait
+}
+
+// refund gives back tokens reserved for a request that was never sent.
+func (b *tokenBucket) refund(n int) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	b.tokens = min(b.perMinute, b.tokens+float64(n))
+	b.mu.Unlock()
+}
+
+// wait takes n tokens, blocking until they are available or ctx is done.
+func (b *tokenBucket) wait(ctx context.Context, n int) error {
+	d := b.reserve(n)
+	if err := sleep(ctx, d); err != nil {
+		b.refund(n)
+		return err
+	}
+	return nil
+}
+
+// sleep waits for d, or returns ctx.Err() if ctx is done first.
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,67 @@
+package rag
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	b := newTokenBucket(60) // one per second
+	b.now = func() time.Time { return now }
+	b.last = now
+
+	for i := range 60 {
+		if d := b.reserve(1); d != 0 {
+			t.Fatalf("reserve %d: expected a full bucket, waited %v", i+1, d)
+		}
+	}
+	if d := b.reserve(1); d != time.Second {
+		t.Fatalf("expected to wait 1s once empty, got %v", d)
+	}
+	if d := b.reserve(1); d != 2*time.Second {
+		t.Fatalf("expected waiters to queue, got %v", d)
+	}
+
+	now = now.Add(time.Minute)
+	if d := b.reserve(30); d != 0 {
+		t.Fatalf("expected the bucket to refill, waited %v", d)
+	}
+
+	// A request larger than the bucket waits for a full bucket only,
+	// and the next one pays for it.
+	now = now.Add(time.Hour)
+	if d := b.reserve(120); d != 0 {
+		t.Fatalf("expected an oversized request to go on a full bucket, waited %v", d)
+	}
+	if d := b.reserve(1); d != 61*time.Second {
+		t.Fatalf("expected to wait 61s after an oversized request, got %v", d)
+	}
+
+	b.refund(1)
+	if d := b.reserve(1); d != 61*time.Second {
+		t.Fatalf("expected a refund to shorten the wait, got %v", d)
+	}
+}
+
+func TestTokenBucket_Wait(t *testing.T) {
+	var b *tokenBucket
+	if err := b.wait(context.Background(), 1000); err != nil {
+		t.Fatalf("expected a nil bucket never to wait, got %v", err)
+	}
+	if newTokenBucket(0) != nil {
+		t.Fatal("expected no bucket without a limit")
+	}
+
+	b = newTokenBucket(1)
+	b.wait(context.Background(), 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := b.wait(ctx, 1); err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if b.tokens >= 1 {
+		t.Fatalf("expected the cancelled wait to be refunded only, got %v tokens", b.tokens)
+	}
+}
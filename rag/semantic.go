@@ -0,0 +1,115 @@
+package rag
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+)
+
+const (
+	defaultSemanticPercentile = 20
+	defaultSemanticMinChars   = 100
+	defaultSemanticMaxChars   = 1500
+)
+
+// SemanticChunker starts a new segment where the topic changes. Every
+// sentence is embedded, and a breakpoint is placed between two adjacent
+// sentences whose cosine similarity is below the Percentile-th percentile
+// of all adjacent similarities in the document. Segments are kept between
+// MinChars and MaxChars: breakpoints that would leave a segment shorter
+// than MinChars are skipped, and a segment is cut anyway before it grows
+// past MaxChars (a single longer sentence is kept whole).
+type SemanticChunker struct {
+	Embedder   Embedder
+	Percentile float64 // 0-100
+	MinChars   int
+	MaxChars   int
+}
+
+// Split is SplitContext without a deadline. If a sentence cannot be
+// embedded it cuts by size alone.
+func (c *SemanticChunker) Split(text string) []Segment {
+	segments, err := c.SplitContext(context.Background(), text)
+	if err != nil {
+		return c.split(text, SplitSentences(text), nil)
+	}
+	return segments
+}
+
+// SplitContext embeds every sentence and returns the first embedding error.
+func (c *SemanticChunker) SplitContext(ctx context.Context, text string) ([]Segment, error) {
+	sentences := SplitSentences(text)
+	texts := make([]string, len(sentences))
+	for i, sp := range sentences {
+		texts[i] = text[sp.Start:sp.End]
+	}
+	embeddings, err := EmbedAll(ctx, c.Embedder, texts)
+	if err != nil {
+		return nil, fmt.Errorf("semantic chunker: embed sentences: %w", err)
+	}
+	return c.split(text, sentences, embeddings), nil
+}
+
+// split places breakpoints between sentences using their embeddings; nil
+// embeddings means no topic breaks, only size cuts.
+func (c *SemanticChunker) split(text string, sentences []Span, embeddings [][]float64) []Segment {
+	percentile := c.Percentile
+	if percentile <= 0 || percentile > 100 {
+		percentile = defaultSemanticPercentile
+	}
+	minChars := c.MinChars
+	if minChars <= 0 {
+		minChars = defaultSemanticMinChars
+	}
+	maxChars := c.MaxChars
+	if maxChars <= 0 {
+		maxChars = defaultSemanticMaxChars
+	}
+
+	if len(sentences) == 0 {
+		return nil
+	}
+
+	// similarities[i] is between sentence i and sentence i+1.
+	similarities := make([]float64, len(sentences)-1)
+	threshold := math.Inf(-1)
+	if embeddings != nil {
+		for i := range similarities {
+			similarities[i] = cosine(embeddings[i], embeddings[i+1])
+		}
+		threshold = percentileOf(similarities, percentile)
+	}
+
+	var segments []Segment
+	start := 0
+	for i := range sentences {
+		if i == len(sentences)-1 {
+			break
+		}
+		size := sentences[i].End - sentences[start].Start
+		grown := sentences[i+1].End - sentences[start].Start
+		if (similarities[i] < threshold && size >= minChars) || grown > maxChars {
+			segments = append(segments, segmentAt(text, sentences[start].Start, sentences[i].End))
+			start = i + 1
+		}
+	}
+	segments = append(segments, segmentAt(text, sentences[start].Start, sentences[len(sentences)-1].End))
+
+	return segments
+}
+
+// percentileOf returns the p-th percentile (0-100) of values using linear
+// interpolation, or +Inf for an empty slice so nothing falls below it.
+func percentileOf(values []float64, p float64) float64 {
+	if len(values) == 0 {
+		return math.Inf(1)
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+
+	rank := p / 100 * float64(len(sorted)-1)
+	lo := int(math.Floor(rank))
+	hi := int(math.Ceil(rank))
+	return sorted[lo] + (sorted[hi]-sorted[lo])*(rank-float64(lo))
+}
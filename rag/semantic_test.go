@@ -0,0 +1,107 @@
+package rag
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// topicEmbedder maps text to how often it mentions each topic, so adjacent
+// sentences about the same topic are similar and a topic switch is not.
+type topicEmbedder struct {
+	topics []string
+}
+
+func (e *topicEmbedder) Embed(ctx context.Context, text string) ([]float64, error) {
+	text = strings.ToLower(text)
+	v := make([]float64, len(e.topics)+1)
+	for i, topic := range e.topics {
+		v[i] = float64(strings.Count(text, topic))
+	}
+	v[len(e.topics)] = 0.1 // keep vectors non-zero
+	return v, nil
+}
+
+func TestSemanticChunker_BreaksOnTopicChange(t *testing.T) {
+	text := "Cats purr a lot. My cat sleeps all day. Cats like boxes. " +
+		"Cars need fuel. A car has four wheels. Cars are loud."
+	c := &SemanticChunker{
+		Embedder: &topicEmbedder{topics: []string{"cat", "car"}},
+		MinChars: 1,
+	}
+	segs := c.Split(text)
+
+	if len(segs) != 2 {
+		t.Fatalf("expected 2 segments, got %d: %+v", len(segs), segs)
+	}
+	if segs[0].Content != "Cats purr a lot. My cat sleeps all day. Cats like boxes." {
+		t.Fatalf("unexpected first segment %q", segs[0].Content)
+	}
+	if !strings.HasPrefix(segs[1].Content, "Cars need fuel.") {
+		t.Fatalf("unexpected second segment %q", segs[1].Content)
+	}
+}
+
+func TestSemanticChunker_RespectsMinAndMax(t *testing.T) {
+	text := "Cats purr. Cars honk. Cats nap. Cars race. Cats hunt. Cars stop."
+	e := &topicEmbedder{topics: []string{"cat", "car"}}
+
+	// Every sentence is a topic switch, but MinChars keeps pairs together.
+	segs := (&SemanticChunker{Embedder: e, MinChars: 15}).Split(text)
+	for i, s := range segs[:len(segs)-1] {
+		if len(s.Content) < 15 {
+			t.Fatalf("segment %d shorter than MinChars: %q", i, s.Content)
+		}
+	}
+
+	// No topic switch at all, but MaxChars still cuts.
+	same := strings.Repeat("Cats are great pets. ", 10)
+	segs = (&SemanticChunker{Embedder: e, MaxChars: 50}).Split(same)
+	for i, s := range segs {
+		if len(s.Content) > 50 {
+			t.Fatalf("segment %d longer than MaxChars: %q", i, s.Content)
+		}
+	}
+	if len(segs) < 4 {
+		t.Fatalf("expected MaxChars to force several segments, got %d", len(segs))
+	}
+}
+
+func TestSemanticChunker_SingleSentence(t *testing.T) {
+	c := &SemanticChunker{Embedder: &fakeEmbedder{}}
+	segs := c.Split("Only one sentence here")
+
+	if len(segs) != 1 || segs[0].Content != "Only one sentence here" {
+		t.Fatalf("expected the sentence as a single segment, got %+v", segs)
+	}
+	if segs := c.Split("   "); len(segs) != 0 {
+		t.Fatalf("expected no segments for blank text, got %+v", segs)
+	}
+}
+
+func TestPercentileOf(t *testing.T) {
+	values := []float64{4, 1, 3, 2}
+	if got := percentileOf(values, 0); got != 1 {
+		t.Fatalf("p0: expected 1, got %f", got)
+	}
+	if got := percentileOf(values, 100); got != 4 {
+		t.Fatalf("p100: expected 4, got %f", got)
+	}
+	if got := percentileOf(values, 50); got != 2.5 {
+		t.Fatalf("p50: expected 2.5, got %f", got)
+	}
+}
+
+func TestSemanticChunker_EmbeddingError(t *testing.T) {
+	c := &SemanticChunker{Embedder: &failingEmbedder{ok: 1}, MinChars: 1}
+	text := "One sentence. Another one. A third."
+
+	if _, err := c.SplitContext(context.Background(), text); !errors.Is(err, ErrEmbedderUnavailable) {
+		t.Fatalf("expected the embedder error, got %v", err)
+	}
+	// Split has no error to return and cuts by size only.
+	if segs := c.Split(text); len(segs) != 1 || segs[0].Content != text {
+		t.Fatalf("expected one segment, got %+v", segs)
+	}
+}
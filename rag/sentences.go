@@ -0,0 +1,175 @@
+package rag
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// abbreviations never end a sentence, whatever follows them.
+// Entries are lower-case and without the final dot. Abbreviations that are
+// also common words ("no", "mar", "ed") are left out on purpose.
+var abbreviations = map[string]bool{
+	"mr": true, "mrs": true, "ms": true, "dr": true, "prof": true, "sr": true, "jr": true,
+	"st": true, "mt": true, "rev": true, "gov": true, "sen": true,
+	"vs": true, "e.g": true, "i.e": true, "cf": true, "approx": true, "ca": true, "viz": true,
+	"fig": true, "figs": true, "vol": true, "pp": true, "ch": true, "eq": true, "ref": true, "dept": true,
+	"jan": true, "feb": true, "apr": true, "jun": true, "jul": true, "aug": true,
+	"sep": true, "sept": true, "oct": true, "nov": true, "dec": true,
+	"u.s": true, "u.k": true, "a.m": true, "p.m": true,
+}
+
+// sentenceFinalAbbreviations often end a sentence too; they only do when
+// the next word starts with an upper-case letter.
+var sentenceFinalAbbreviations = map[string]bool{
+	"etc": true, "inc": true, "ltd": true, "co": true, "corp": true, "al": true,
+}
+
+// SplitSentences returns the sentences of text as spans of the original
+// text, so text[span.Start:span.End] is the sentence byte for byte.
+// Whitespace between sentences belongs to no span.
+//
+// A sentence ends at ".", "?", "!" or an ellipsis (plus any closing quotes
+// or brackets) followed by whitespace, or at a blank line. Dots inside
+// tokens (3.14, v1.12.0, example.com) and after known abbreviations or
+// initials do not end a sentence.
+func SplitSentences(text string) []Span {
+	var spans []Span
+	start := -1
+
+	emit := func(end int) {
+		if start < 0 {
+			return
+		}
+		end = start + len(strings.TrimRightFunc(text[start:end], unicode.IsSpace))
+		if end > start {
+			spans = append(spans, Span{start, end})
+		}
+		start = -1
+	}
+
+	for i := 0; i < len(text); {
+		r, w := utf8.DecodeRuneInString(text[i:])
+		if start < 0 {
+			if unicode.IsSpace(r) {
+				i += w
+				continue
+			}
+			start = i
+		}
+
+		switch {
+		case r == '\n':
+			// A blank line always ends the sentence, punctuation or not.
+			next := scan(text, i, unicode.IsSpace)
+			if strings.Count(text[i:next], "\n") >= 2 {
+				emit(i)
+			}
+			i = next
+		case isTerminator(r):
+			termEnd := scan(text, i, isTerminator)
+			end := scan(text, termEnd, isCloser)
+			if isSentenceEnd(text, start, i, termEnd, end) {
+				emit(end)
+			}
+			i = end
+		default:
+			i += w
+		}
+	}
+	emit(len(text))
+	return spans
+}
+
+// isSentenceEnd reports whether the terminators text[at:termEnd], followed
+// by closing quotes or brackets up to end, close the sentence that started
+// at start.
+func isSentenceEnd(text string, start, at, termEnd, end int) bool {
+	if end == len(text) {
+		return true
+	}
+	next, _ := utf8.DecodeRuneInString(text[end:])
+	if !unicode.IsSpace(next) {
+		return false // 3.14, example.com, v1.12.0, "?!" inside a token
+	}
+	following := strings.TrimLeftFunc(text[end:], unicode.IsSpace)
+	if following == "" {
+		return true
+	}
+	first, _ := utf8.DecodeRuneInString(following)
+
+	// Only a lone "." can be an abbreviation; "?", "!" and "..." always end
+	// the sentence unless the text clearly continues in lower case.
+	if text[at:termEnd] == "." {
+		word := strings.ToLower(lastWord(text[start:at]))
+		switch {
+		case abbreviations[word]:
+			return false
+		case sentenceFinalAbbreviations[word]:
+			return unicode.IsUpper(first)
+		case isInitial(text[start:termEnd], following):
+			return false // "J. R. R. Tolkien", "by J. Smith"
+		}
+	}
+	return !unicode.IsLower(first)
+}
+
+// isInitial reports whether s, which ends with a dot, ends with the
+// initial of a name, given the text that follows. A lone capital is only
+// an initial next to another initial or before a capitalised word that is
+// not a common sentence opener, so "plan B. Then" and "Grade A. Next"
+// still split.
+func isInitial(s, following string) bool {
+	words := strings.Fields(s)
+	if !isInitialWord(words[len(words)-1]) {
+		return false
+	}
+	if len(words) > 1 && isInitialWord(words[len(words)-2]) {
+		return true // the end of "J. R. R."
+	}
+	next, _, _ := strings.Cut(following, " ")
+	if isInitialWord(next) {
+		return true
+	}
+	name := strings.TrimRightFunc(next, func(r rune) bool { return !unicode.IsLetter(r) })
+	first, _ := utf8.DecodeRuneInString(name)
+	return unicode.IsUpper(first) && !sentenceStarters[strings.ToLower(name)]
+}
+
+// isInitialWord reports whether w is a single capital letter and a dot,
+// possibly after an opening bracket or quote.
+func isInitialWord(w string) bool {
+	w, ok := strings.CutSuffix(lastWord(w), ".")
+	r, size := utf8.DecodeRuneInString(w)
+	return ok && size > 0 && size == len(w) && unicode.IsUpper(r)
+}
+
+// sentenceStarters are capitalised words more likely to open a sentence
+// than to be a name after an initial. Lower-case.
+var sentenceStarters = func() map[string]bool {
+	out := map[string]bool{}
+	for _, w := range strings.Fields(`a after all also an and as at before but each every finally
+		first for he her here his how however i if in it its my next no not now on once our
+		please see she so some that the their then there these they this those to we what
+		when where which while who why yes you your`) {
+		out[w] = true
+	}
+	return out
+}()
+
+// lastWord returns the trailing run of s that is not whitespace or an
+// opening bracket/quote, e.g. "e.g" for "(see e.g".
+func lastWord(s string) string {
+	i := strings.LastIndexFunc(s, func(r rune) bool {
+		return unicode.IsSpace(r) || strings.ContainsRune("([{\"'“‘", r)
+	})
+	return s[i+1:]
+}
+
+func isTerminator(r rune) bool {
+	return r == '.' || r == '?' || r == '!' || r == '…'
+}
+
+func isCloser(r rune) bool {
+	return strings.ContainsRune(")]}\"'’”»", r)
+}
@@ -0,0 +1,108 @@
+package rag
+
+import (
+	"strings"
+	"testing"
+)
+
+func sentenceTexts(text string) []string {
+	var out []string
+	for _, sp := range SplitSentences(text) {
+		out = append(out, text[sp.Start:sp.End])
+	}
+	return out
+}
+
+func TestSplitSentences(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{
+			name: "basic",
+			text: "Sentence one. Sentence two.",
+			want: []string{"Sentence one.", "Sentence two."},
+		},
+		{
+			name: "question_and_exclamation",
+			text: "Is it fast? Yes! Very fast.",
+			want: []string{"Is it fast?", "Yes!", "Very fast."},
+		},
+		{
+			name: "abbreviations",
+			text: "Use a store, e.g. Postgres. Ask Dr. Smith about it.",
+			want: []string{"Use a store, e.g. Postgres.", "Ask Dr. Smith about it."},
+		},
+		{
+			name: "decimals_versions_urls",
+			text: "Pi is 3.14 roughly. Upgrade to v1.12.0 from example.com today. Done",
+			want: []string{"Pi is 3.14 roughly.", "Upgrade to v1.12.0 from example.com today.", "Done"},
+		},
+		{
+			name: "ellipsis",
+			text: "Wait... Then it worked. Well… Fine.",
+			want: []string{"Wait...", "Then it worked.", "Well…", "Fine."},
+		},
+		{
+			name: "closing_quotes",
+			text: `He said "stop." Then he left.`,
+			want: []string{`He said "stop."`, "Then he left."},
+		},
+		{
+			name: "lowercase_continuation",
+			text: "Apples, pears, etc. are fruit. Initials like J. R. R. Tolkien stay.",
+			want: []string{"Apples, pears, etc. are fruit.", "Initials like J. R. R. Tolkien stay."},
+		},
+		{
+			name: "initials",
+			text: "Written by J. Smith and J. R. R. Tolkien. I bought plan B. Then it broke. Grade A. Next item.",
+			want: []string{"Written by J. Smith and J. R. R. Tolkien.", "I bought plan B.", "Then it broke.", "Grade A.", "Next item."},
+		},
+		{
+			name: "lower_case_letter",
+			text: "Pick option a. The rest are wrong.",
+			want: []string{"Pick option a.", "The rest are wrong."},
+		},
+		{
+			name: "sentence_final_abbreviation",
+			text: "We sell apples, pears, etc. Prices vary.",
+			want: []string{"We sell apples, pears, etc.", "Prices vary."},
+		},
+		{
+			name: "blank_line",
+			text: "Title without dot\n\nBody text here.",
+			want: []string{"Title without dot", "Body text here."},
+		},
+		{
+			name: "empty",
+			text: "   \n ",
+			want: nil,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := sentenceTexts(tc.text)
+			if strings.Join(got, "|") != strings.Join(tc.want, "|") {
+				t.Fatalf("SplitSentences(%q) = %q, want %q", tc.text, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSentenceChunker_PreservesText(t *testing.T) {
+	text := "First, e.g. this one!  Second?\nThird is v1.2.3. Fourth."
+	c := &SentenceChunker{MaxSentences: 2}
+	segs := c.Split(text)
+
+	if len(segs) != 2 {
+		t.Fatalf("expected 2 segments, got %d: %+v", len(segs), segs)
+	}
+	if segs[0].Content != "First, e.g. this one!  Second?" {
+		t.Fatalf("unexpected first segment %q", segs[0].Content)
+	}
+	if segs[1].Content != "Third is v1.2.3. Fourth." {
+		t.Fatalf("unexpected second segment %q", segs[1].Content)
+	}
+}
@@ -1,25 +1,177 @@
 package rag
 
 import (
+	"container/heap"
+	"fmt"
 	"math"
+	"runtime"
+	"sort"
 	"sync"
 )
 
+// parallelSearchMin is the store size from which exact search scores
+// shards of the store on several goroutines. Below it the goroutine
+// overhead costs more than it saves.
+var parallelSearchMin = 16384
+
 type InMemoryStore struct {
 	mu     sync.RWMutex
 	chunks []Chunk
+	// vecs[i] is chunks[i].Embedding scaled to unit length, so cosine
+	// similarity is a plain dot product at search time.
+	vecs     [][]float64
+	pos      map[string]int // chunk ID -> index in chunks
+	index    *HNSWIndex     // nil: exact search
+	keywords *BM25Index
 }
 
 func NewInMemoryStore() *InMemoryStore {
 	return &InMemoryStore{
-		chunks: []Chunk{},
+		chunks:   []Chunk{},
+		pos:      map[string]int{},
+		keywords: NewBM25Index(BM25Options{}),
+	}
+}
+
+// NewHNSWStore returns an InMemoryStore whose Search goes through an HNSW
+// index instead of scoring every chunk. Results are approximate; see
+// HNSWIndex.
+func NewHNSWStore(opts HNSWOptions) *InMemoryStore {
+	s := NewInMemoryStore()
+	s.index = NewHNSWIndex(opts)
+	return s
+}
+
+func (s *InMemoryStore) Add(chunks ...Chunk) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.append(chunks)
+	return nil
+}
+
+// append adds chunks and their normalized vectors. A chunk whose ID is
+// already stored, or comes again later in chunks, replaces the earlier
+// one, as in the HNSW and keyword indexes. The caller holds s.mu.
+func (s *InMemoryStore) append(chunks []Chunk) {
+	last := make(map[string]int, len(chunks))
+	for i, ch := range chunks {
+		last[ch.ID] = i
+	}
+	if len(last) < len(chunks) {
+		unique := make([]Chunk, 0, len(last))
+		for i, ch := range chunks {
+			if last[ch.ID] == i {
+				unique = append(unique, ch)
+			}
+		}
+		chunks = unique
+	}
+	for _, ch := range chunks {
+		if _, ok := s.pos[ch.ID]; ok {
+			s.keep(func(ch Chunk) bool {
+				_, again := last[ch.ID]
+				return !again
+			})
+			break
+		}
+	}
+
+	for _, ch := range chunks {
+		s.pos[ch.ID] = len(s.chunks)
+		s.chunks = append(s.chunks, ch)
+		s.vecs = append(s.vecs, normalized(ch.Embedding))
+	}
+	if s.index != nil {
+		s.index.Insert(chunks...)
+	}
+	s.keywords.Add(chunks...)
+}
+
+// keep drops every chunk for which keep returns false, along with its
+// vector, and returns the IDs of the dropped chunks. The caller holds s.mu.
+func (s *InMemoryStore) keep(keep func(Chunk) bool) []string {
+	var dropped []string
+	n := 0
+	for i, ch := range s.chunks {
+		if keep(ch) {
+			s.chunks[n], s.vecs[n] = ch, s.vecs[i]
+			s.pos[ch.ID] = n
+			n++
+		} else {
+			dropped = append(dropped, ch.ID)
+			delete(s.pos, ch.ID)
+		}
+	}
+	// Release embeddings of dropped chunks.
+	clear(s.chunks[n:])
+	clear(s.vecs[n:])
+	s.chunks, s.vecs = s.chunks[:n], s.vecs[:n]
+	if len(dropped) > 0 {
+		if s.index != nil {
+			s.index.Delete(dropped...)
+		}
+		s.keywords.Delete(dropped...)
 	}
+	return dropped
 }
 
-func (s *InMemoryStore) Add(chunks ...Chunk) {
+// AddDocument adds the chunks of one document. If chunks of a document with
+// the same ID are already stored, the policy decides: ConflictReject returns
+// ErrDocumentExists and stores nothing, ConflictReplace drops the old chunks
+// and adds the new ones in one step, so searches never see both or neither.
+// It returns how many old chunks were replaced.
+func (s *InMemoryStore) AddDocument(docID string, policy ConflictPolicy, chunks ...Chunk) (int, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.chunks = append(s.chunks, chunks...)
+
+	replaced := s.countDocumentLocked(docID)
+	if replaced > 0 {
+		if policy != ConflictReplace {
+			return 0, fmt.Errorf("%w: %q", ErrDocumentExists, docID)
+		}
+		s.keep(func(ch Chunk) bool { return ch.DocumentID != docID })
+	}
+
+	s.append(chunks)
+	return replaced, nil
+}
+
+// HasDocument reports whether any chunk of the document is stored.
+func (s *InMemoryStore) HasDocument(docID string) bool {
+	return s.countDocument(docID) > 0
+}
+
+func (s *InMemoryStore) countDocument(docID string) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.countDocumentLocked(docID)
+}
+
+func (s *InMemoryStore) countDocumentLocked(docID string) int {
+	n := 0
+	for _, ch := range s.chunks {
+		if ch.DocumentID == docID {
+			n++
+		}
+	}
+	return n
+}
+
+// countIDs returns how many of the given chunk IDs are stored.
+func (s *InMemoryStore) countIDs(ids []string) int {
+	want := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		want[id] = true
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	n := 0
+	for id := range want {
+		if _, ok := s.pos[id]; ok {
+			n++
+		}
+	}
+	return n
 }
 
 // naive cosine similarity
@@ -39,36 +191,295 @@ func cosine(a, b []float64) float64 {
 	return dot / (math.Sqrt(na) * math.Sqrt(nb))
 }
 
+// dot is the cosine similarity of two normalized vectors, 0 when their
+// lengths differ (like cosine).
+func dot(a, b []float64) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var sum float64
+	for i := range a {
+		sum += a[i] * b[i]
+	}
+	// Rounding can push unit vectors just past 1.
+	return min(max(sum, -1), 1)
+}
+
+// normalized returns v scaled to unit length, or a zero vector for a zero v.
+func normalized(v []float64) []float64 {
+	var norm float64
+	for _, x := range v {
+		norm += x * x
+	}
+	out := make([]float64, len(v))
+	if norm == 0 {
+		return out
+	}
+	norm = math.Sqrt(norm)
+	for i, x := range v {
+		out[i] = x / norm
+	}
+	return out
+}
+
+// Search scores every chunk (or asks the HNSW index, see NewHNSWStore).
+// Only the best topK are kept while scoring, in a bounded min-heap, and
+// large stores are scored in parallel shards whose heaps are merged.
+// Equal scores keep insertion order.
 func (s *InMemoryStore) Search(queryEmbedding []float64, topK int) []SearchResult {
+	return s.SearchWith(queryEmbedding, topK, SearchOptions{})
+}
+
+// SearchWith is Search with options. Chunks that fail the filter are
+// skipped before scoring.
+func (s *InMemoryStore) SearchWith(queryEmbedding []float64, topK int, opts SearchOptions) []SearchResult {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	results := make([]SearchResult, 0, len(s.chunks))
-	for _, ch := range s.chunks {
-		score := cosine(queryEmbedding, ch.Embedding)
-		results = append(results, SearchResult{
-			Chunk: ch,
-			Score: score,
-		})
+	if topK <= 0 {
+		return []SearchResult{}
 	}
+	var keep func(Chunk) bool
+	if opts.Filter != nil {
+		keep = opts.Filter.matcher()
+	}
+	if s.index != nil {
+		return s.index.SearchFunc(queryEmbedding, topK, keep)
+	}
+
+	q := normalized(queryEmbedding)
+	var best *topKHeap
+	if shards := runtime.GOMAXPROCS(0); len(s.vecs) >= parallelSearchMin && shards > 1 {
+		best = s.searchParallel(q, topK, shards, keep)
+	} else {
+		best = s.searchRange(q, topK, 0, len(s.vecs), keep)
+	}
+
+	hits := best.sorted()
+	results := make([]SearchResult, len(hits))
+	for i, h := range hits {
+		results[i] = SearchResult{Chunk: s.chunks[h.pos], Score: h.score}
+	}
+	return results
+}
+
+// KeywordSearch ranks chunks by BM25 over their Content (see BM25Index).
+// The keyword index is kept up to date on every write.
+func (s *InMemoryStore) KeywordSearch(query string, topK int, opts SearchOptions) []SearchResult {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var keep func(Chunk) bool
+	if opts.Filter != nil {
+		keep = opts.Filter.matcher()
+	}
+	return s.keywords.SearchFunc(query, topK, keep)
+}
+
+// CountMatches scores every chunk against q without ranking them. Stores
+// with an HNSW index only count keyword matches.
+func (s *InMemoryStore) CountMatches(q CountQuery) (int, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-	// sort by score desc (simple bubble-ish, OK for small demo)
-	for i := 0; i < len(results); i++ {
-		for j := i + 1; j < len(results); j++ {
-			if results[j].Score > results[i].Score {
-				results[i], results[j] = results[j], results[i]
+	if q.Embedding != nil && s.index != nil {
+		return 0, false
+	}
+	var keep func(Chunk) bool
+	if q.Filter != nil {
+		keep = q.Filter.matcher()
+	}
+	matched := map[string]bool{}
+	if q.Text != "" {
+		matched = s.keywords.matching(q.Text, q.MinKeywordScore, keep)
+	}
+	n := len(matched)
+	if q.Embedding != nil {
+		v := normalized(q.Embedding)
+		for i, ch := range s.chunks {
+			if matched[ch.ID] || keep != nil && !keep(ch) {
+				continue
 			}
+			if dot(v, s.vecs[i]) >= q.MinScore {
+				n++
+			}
+		}
+	}
+	return n, true
+}
+
+// searchRange returns the best topK of vecs[lo:hi] among the chunks keep
+// accepts (nil accepts all). The caller holds s.mu.
+func (s *InMemoryStore) searchRange(q []float64, topK, lo, hi int, keep func(Chunk) bool) *topKHeap {
+	best := &topKHeap{k: topK}
+	for i := lo; i < hi; i++ {
+		if keep != nil && !keep(s.chunks[i]) {
+			continue
 		}
+		best.offer(scoredPos{pos: i, score: dot(q, s.vecs[i])})
+	}
+	return best
+}
+
+// searchParallel splits the store into shards scored concurrently and
+// merges their top-K. The caller holds s.mu.
+func (s *InMemoryStore) searchParallel(q []float64, topK, shards int, keep func(Chunk) bool) *topKHeap {
+	size := (len(s.vecs) + shards - 1) / shards
+	partial := make([]*topKHeap, shards)
+	var wg sync.WaitGroup
+	for i := range partial {
+		lo, hi := i*size, min((i+1)*size, len(s.vecs))
+		wg.Go(func() {
+			partial[i] = s.searchRange(q, topK, lo, hi, keep)
+		})
+	}
+	wg.Wait()
+
+	best := &topKHeap{k: topK}
+	for _, p := range partial {
+		for _, h := range p.items {
+			best.offer(h)
+		}
+	}
+	return best
+}
+
+// scoredPos is the score of the chunk at index pos of the store.
+type scoredPos struct {
+	pos   int
+	score float64
+}
+
+// worse orders hits by score, then by insertion order.
+func (a scoredPos) worse(b scoredPos) bool {
+	if a.score != b.score {
+		return a.score < b.score
+	}
+	return a.pos > b.pos
+}
+
+// topKHeap keeps the k best hits offered to it, the worst of them on top.
+type topKHeap struct {
+	k     int
+	items []scoredPos
+}
+
+func (h *topKHeap) offer(x scoredPos) {
+	if len(h.items) < h.k {
+		heap.Push(h, x)
+	} else if h.items[0].worse(x) {
+		h.items[0] = x
+		heap.Fix(h, 0)
 	}
+}
+
+// sorted returns the hits best first.
+func (h *topKHeap) sorted() []scoredPos {
+	sort.Slice(h.items, func(i, j int) bool { return h.items[j].worse(h.items[i]) })
+	return h.items
+}
+
+func (h *topKHeap) Len() int           { return len(h.items) }
+func (h *topKHeap) Less(i, j int) bool { return h.items[i].worse(h.items[j]) }
+func (h *topKHeap) Swap(i, j int)      { h.items[i], h.items[j] = h.items[j], h.items[i] }
+func (h *topKHeap) Push(x any)         { h.items = append(h.items, x.(scoredPos)) }
+func (h *topKHeap) Pop() any {
+	last := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return last
+}
+
+func (s *InMemoryStore) Delete(ids ...string) (int, error) {
+	drop := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		drop[id] = true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.keep(func(ch Chunk) bool { return !drop[ch.ID] })), nil
+}
 
-	if topK > len(results) {
-		topK = len(results)
+// DeleteDocument removes every chunk of the document and returns how many
+// there were.
+func (s *InMemoryStore) DeleteDocument(docID string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.keep(func(ch Chunk) bool { return ch.DocumentID != docID })), nil
+}
+
+// DeleteSource removes every chunk whose Source is source, whatever
+// document it belongs to, and returns how many there were.
+func (s *InMemoryStore) DeleteSource(source string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.keep(func(ch Chunk) bool { return ch.Source != source })), nil
+}
+
+func (s *InMemoryStore) countSource(source string) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	n := 0
+	for _, ch := range s.chunks {
+		if ch.Source == source {
+			n++
+		}
 	}
-	return results[:topK]
+	return n
 }
 
-func (s *InMemoryStore) Clear() {
+func (s *InMemoryStore) Clear() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.chunks = nil
-}
\ No newline at end of file
+	s.chunks, s.vecs = nil, nil
+	clear(s.pos)
+	if s.index != nil {
+		s.index.Reset()
+	}
+	s.keywords.Reset()
+	return nil
+}
+
+func (s *InMemoryStore) Count() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.chunks)
+}
+
+// Documents lists the stored documents in upload order.
+func (s *InMemoryStore) Documents() []DocumentInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return summarizeDocuments(s.chunks)
+}
+
+// DocumentChunks returns the chunks of one document ordered by Index, or
+// nil if the document is not stored.
+func (s *InMemoryStore) DocumentChunks(docID string) []Chunk {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var out []Chunk
+	for _, ch := range s.chunks {
+		if ch.DocumentID == docID {
+			out = append(out, ch)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Index < out[j].Index })
+	return out
+}
+
+// Chunk returns the chunk with the given ID.
+func (s *InMemoryStore) Chunk(id string) (Chunk, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if i, ok := s.pos[id]; ok {
+		return s.chunks[i], true
+	}
+	return Chunk{}, false
+}
+
+func (s *InMemoryStore) List() []Chunk {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]Chunk(nil), s.chunks...)
+}
@@ -0,0 +1,38 @@
+package rag_test
+
+import (
+	"testing"
+
+	"go-rag-demo/rag"
+	"go-rag-demo/rag/storetest"
+)
+
+func TestInMemoryStore_Conformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) rag.VectorStore {
+		return rag.NewInMemoryStore()
+	})
+}
+
+func TestFileStore_Conformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) rag.VectorStore {
+		s, err := rag.OpenFileStore(t.TempDir(), rag.FileStoreOptions{SnapshotEvery: 7})
+		if err != nil {
+			t.Fatalf("OpenFileStore: %v", err)
+		}
+		t.Cleanup(func() { s.Close() })
+		return s
+	})
+}
+
+func TestHNSWStore_Conformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) rag.VectorStore {
+		return rag.NewHNSWStore(rag.HNSWOptions{})
+	})
+}
+
+func TestCollection_Conformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) rag.VectorStore {
+		coll, _ := rag.NewCollections(func() rag.VectorStore { return rag.NewInMemoryStore() }).Get(rag.DefaultCollection)
+		return coll
+	})
+}
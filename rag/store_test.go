@@ -1,6 +1,12 @@
 package rag
 
-import "testing"
+import (
+	"fmt"
+	"math/rand/v2"
+	"runtime"
+	"sort"
+	"testing"
+)
 
 func TestInMemoryStore_AddAndSearch(t *testing.T) {
 	store := NewInMemoryStore()
@@ -51,3 +57,143 @@ func TestInMemoryStore_SearchTopKBounds(t *testing.T) {
 		t.Fatalf("expected 2 results when topK > len(chunks), got %d", len(res))
 	}
 }
+
+func TestInMemoryStore_SearchTiesKeepInsertionOrder(t *testing.T) {
+	store := NewInMemoryStore()
+	store.Add(
+		Chunk{ID: "1", Embedding: []float64{1, 0}},
+		Chunk{ID: "2", Embedding: []float64{0, 1}},
+		Chunk{ID: "3", Embedding: []float64{2, 0}}, // same direction as 1
+		Chunk{ID: "4", Embedding: []float64{1, 0}},
+	)
+
+	res := store.Search([]float64{1, 0}, 3)
+	if len(res) != 3 || res[0].Chunk.ID != "1" || res[1].Chunk.ID != "3" || res[2].Chunk.ID != "4" {
+		t.Fatalf("expected [1 3 4], got %+v", res)
+	}
+}
+
+func TestInMemoryStore_SearchParallelMatchesSequential(t *testing.T) {
+	rng := rand.New(rand.NewPCG(4, 4))
+	store := NewInMemoryStore()
+	store.Add(randomChunks(rng, 1000, 16)...)
+	q := normalized(randomVector(rng, 16))
+
+	want := store.searchRange(q, 25, 0, 1000, nil).sorted()
+	got := store.searchParallel(q, 25, 7, nil).sorted()
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("parallel search differs:\n got %v\nwant %v", got, want)
+	}
+}
+
+func TestInMemoryStore_SearchAfterDelete(t *testing.T) {
+	store := NewInMemoryStore()
+	store.Add(
+		Chunk{ID: "1", Embedding: []float64{1, 0}},
+		Chunk{ID: "2", Embedding: []float64{0.9, 0.1}},
+		Chunk{ID: "3", Embedding: []float64{0, 1}},
+	)
+	store.Delete("1")
+
+	// Vectors must stay aligned with their chunks after compaction.
+	res := store.Search([]float64{0, 1}, 1)
+	if len(res) != 1 || res[0].Chunk.ID != "3" || res[0].Score < 0.99 {
+		t.Fatalf("expected chunk 3 with score ~1, got %+v", res)
+	}
+}
+
+func TestInMemoryStore_CountMatches(t *testing.T) {
+	store := NewInMemoryStore()
+	store.Add(
+		Chunk{ID: "1", Source: "a", Content: "refund policy", Embedding: []float64{1, 0}},
+		Chunk{ID: "2", Source: "a", Content: "shipping times", Embedding: []float64{0.9, 0.1}},
+		Chunk{ID: "3", Source: "b", Content: "refund form", Embedding: []float64{0, 1}},
+		Chunk{ID: "4", Source: "b", Content: "office hours", Embedding: []float64{0.1, 0.9}},
+	)
+	source := "a"
+	q := []float64{1, 0}
+
+	cases := []struct {
+		name string
+		q    CountQuery
+		want int
+	}{
+		{"vector", CountQuery{Embedding: q, MinScore: 0.5}, 2},
+		{"keyword", CountQuery{Text: "refund"}, 2},
+		{"both, counted once", CountQuery{Embedding: q, MinScore: 0.5, Text: "refund"}, 3},
+		{"filtered", CountQuery{Embedding: q, MinScore: 0.5, Text: "refund", Filter: &Filter{Field: "source", Eq: &source}}, 2},
+		{"keyword threshold", CountQuery{Text: "refund", MinKeywordScore: 100}, 0},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			n, ok := store.CountMatches(tc.q)
+			if !ok || n != tc.want {
+				t.Fatalf("expected %d matches, got %d (ok=%t)", tc.want, n, ok)
+			}
+			// The count agrees with what the searches find.
+			if tc.q.Text == "" {
+				if got := len(atLeastScore(store.SearchWith(q, 10, SearchOptions{}), tc.q.MinScore)); got != n {
+					t.Fatalf("search found %d, count says %d", got, n)
+				}
+			}
+		})
+	}
+
+	hnsw := NewHNSWStore(HNSWOptions{})
+	hnsw.Add(store.List()...)
+	if _, ok := hnsw.CountMatches(CountQuery{Embedding: q}); ok {
+		t.Fatal("expected an HNSW store not to count vector matches")
+	}
+	if n, ok := hnsw.CountMatches(CountQuery{Text: "refund"}); !ok || n != 2 {
+		t.Fatalf("expected an HNSW store to count 2 keyword matches, got %d (ok=%t)", n, ok)
+	}
+}
+
+func atLeastScore(results []SearchResult, minScore float64) []SearchResult {
+	var kept []SearchResult
+	for _, r := range results {
+		if r.Score >= minScore {
+			kept = append(kept, r)
+		}
+	}
+	return kept
+}
+
+// searchSortAll is the previous approach: score every chunk into a result
+// slice and sort all of it.
+func searchSortAll(chunks []Chunk, q []float64, topK int) []SearchResult {
+	results := make([]SearchResult, 0, len(chunks))
+	for _, ch := range chunks {
+		results = append(results, SearchResult{Chunk: ch, Score: cosine(q, ch.Embedding)})
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Score > results[j].Score })
+	return results[:min(topK, len(results))]
+}
+
+func BenchmarkInMemoryStore_Search(b *testing.B) {
+	const dim, k = 256, 10
+	for _, n := range []int{1000, 10000, 100000} {
+		rng := rand.New(rand.NewPCG(5, 5))
+		store := NewInMemoryStore()
+		store.Add(randomChunks(rng, n, dim)...)
+		q := randomVector(rng, dim)
+		nq := normalized(q)
+
+		b.Run(fmt.Sprintf("n=%d/sort-all", n), func(b *testing.B) {
+			for b.Loop() {
+				searchSortAll(store.chunks, q, k)
+			}
+		})
+		b.Run(fmt.Sprintf("n=%d/heap", n), func(b *testing.B) {
+			for b.Loop() {
+				store.searchRange(nq, k, 0, n, nil)
+			}
+		})
+		b.Run(fmt.Sprintf("n=%d/heap-parallel", n), func(b *testing.B) {
+			shards := max(runtime.GOMAXPROCS(0), 2)
+			for b.Loop() {
+				store.searchParallel(nq, k, shards, nil)
+			}
+		})
+	}
+}
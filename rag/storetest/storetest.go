@@ -0,0 +1,443 @@
+// Package storetest provides a conformance suite for rag.VectorStore
+// implementations.
+//
+// A backend runs it from its own tests:
+//
+//	func TestMyStore(t *testing.T) {
+//		storetest.Run(t, func(t *testing.T) rag.VectorStore {
+//			return NewMyStore(t.TempDir())
+//		})
+//	}
+package storetest
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"go-rag-demo/rag"
+)
+
+// Run checks that stores returned by newStore behave like a rag.VectorStore.
+// newStore is called once per subtest and must return an empty store.
+func Run(t *testing.T, newStore func(t *testing.T) rag.VectorStore) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, s rag.VectorStore)
+	}{
+		{"AddCountList", testAddCountList},
+		{"AddReplacesSameID", testAddReplacesSameID},
+		{"SearchRanksByCosine", testSearchRanksByCosine},
+		{"SearchTopKBounds", testSearchTopKBounds},
+		{"SearchEmptyStore", testSearchEmptyStore},
+		{"SearchWithFilter", testSearchWithFilter},
+		{"KeywordSearch", testKeywordSearch},
+		{"Delete", testDelete},
+		{"DeleteDocument", testDeleteDocument},
+		{"DeleteSource", testDeleteSource},
+		{"Clear", testClear},
+		{"AddDocumentConflicts", testAddDocumentConflicts},
+		{"ListReturnsCopy", testListReturnsCopy},
+		{"Documents", testDocuments},
+		{"DocumentChunksAndChunk", testDocumentChunksAndChunk},
+		{"Concurrency", testConcurrency},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.fn(t, newStore(t))
+		})
+	}
+}
+
+func chunk(id, docID string, embedding ...float64) rag.Chunk {
+	return rag.Chunk{ID: id, DocumentID: docID, Content: "content " + id, Source: docID, Embedding: embedding}
+}
+
+func mustAdd(t *testing.T, s rag.VectorStore, chunks ...rag.Chunk) {
+	t.Helper()
+	if err := s.Add(chunks...); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+}
+
+func ids(chunks []rag.Chunk) []string {
+	out := make([]string, len(chunks))
+	for i, ch := range chunks {
+		out[i] = ch.ID
+	}
+	return out
+}
+
+func resultIDs(results []rag.SearchResult) []string {
+	out := make([]string, len(results))
+	for i, r := range results {
+		out[i] = r.Chunk.ID
+	}
+	return out
+}
+
+func testAddCountList(t *testing.T, s rag.VectorStore) {
+	if n := s.Count(); n != 0 {
+		t.Fatalf("new store: expected Count 0, got %d", n)
+	}
+	mustAdd(t, s, chunk("a", "d1", 1, 0), chunk("b", "d1", 0, 1))
+	mustAdd(t, s, chunk("c", "d2", 1, 1))
+
+	if n := s.Count(); n != 3 {
+		t.Fatalf("expected Count 3, got %d", n)
+	}
+	got := s.List()
+	if fmt.Sprint(ids(got)) != "[a b c]" {
+		t.Fatalf("expected List in insertion order [a b c], got %v", ids(got))
+	}
+	if got[0].Content != "content a" || got[0].DocumentID != "d1" || len(got[0].Embedding) != 2 {
+		t.Fatalf("chunk fields not preserved: %+v", got[0])
+	}
+}
+
+func testAddReplacesSameID(t *testing.T, s rag.VectorStore) {
+	mustAdd(t, s, chunk("a", "d1", 1, 0), chunk("b", "d1", 0, 1))
+	again := chunk("a", "d2", 0, 1)
+	again.Content = "replacement text"
+	mustAdd(t, s, again, chunk("c", "d2", 1, 1), chunk("c", "d2", 1, 1))
+
+	if n := s.Count(); n != 3 {
+		t.Fatalf("expected Count 3 after re-adding a and c, got %d", n)
+	}
+	if ch, ok := s.Chunk("a"); !ok || ch.Content != "replacement text" || ch.DocumentID != "d2" {
+		t.Fatalf("expected the replacement for a, got %+v", ch)
+	}
+	// Every view sees the one chunk a.
+	if got := resultIDs(s.Search([]float64{0, 1}, 10)); fmt.Sprint(got) != "[a b c]" && fmt.Sprint(got) != "[b a c]" {
+		t.Fatalf("expected a once in vector search, got %v", got)
+	}
+	if got := resultIDs(s.KeywordSearch("replacement", 10, rag.SearchOptions{})); fmt.Sprint(got) != "[a]" {
+		t.Fatalf("expected a in keyword search, got %v", got)
+	}
+	if got := s.KeywordSearch("content", 10, rag.SearchOptions{}); len(got) != 2 {
+		t.Fatalf("expected the old text of a gone from keyword search, got %v", resultIDs(got))
+	}
+	if n, err := s.Delete("a"); err != nil || n != 1 {
+		t.Fatalf("Delete: expected 1, got %d, %v", n, err)
+	}
+	if fmt.Sprint(ids(s.List())) != "[b c]" {
+		t.Fatalf("expected [b c] left, got %v", ids(s.List()))
+	}
+}
+
+func testSearchRanksByCosine(t *testing.T, s rag.VectorStore) {
+	mustAdd(t, s,
+		chunk("x", "d", 1, 0),
+		chunk("y", "d", 0, 1),
+		chunk("xy", "d", 1, 1),
+	)
+
+	results := s.Search([]float64{0.9, 0.1}, 3)
+	if fmt.Sprint(resultIDs(results)) != "[x xy y]" {
+		t.Fatalf("expected ranking [x xy y], got %v", resultIDs(results))
+	}
+	for i := 1; i < len(results); i++ {
+		if results[i].Score > results[i-1].Score {
+			t.Fatalf("results not sorted by score: %v", results)
+		}
+	}
+	if s := results[0].Score; s < 0.99 || s > 1.0 {
+		t.Fatalf("expected cosine score ~0.994 for the best match, got %f", s)
+	}
+}
+
+func testSearchTopKBounds(t *testing.T, s rag.VectorStore) {
+	mustAdd(t, s, chunk("a", "d", 1, 0), chunk("b", "d", 0, 1))
+
+	if n := len(s.Search([]float64{1, 0}, 10)); n != 2 {
+		t.Fatalf("topK > Count: expected 2 results, got %d", n)
+	}
+	if n := len(s.Search([]float64{1, 0}, 1)); n != 1 {
+		t.Fatalf("topK 1: expected 1 result, got %d", n)
+	}
+	if n := len(s.Search([]float64{1, 0}, 0)); n != 0 {
+		t.Fatalf("topK 0: expected no results, got %d", n)
+	}
+	if n := len(s.Search([]float64{1, 0}, -1)); n != 0 {
+		t.Fatalf("negative topK: expected no results, got %d", n)
+	}
+}
+
+func testSearchEmptyStore(t *testing.T, s rag.VectorStore) {
+	results := s.Search([]float64{1, 0}, 3)
+	if results == nil || len(results) != 0 {
+		t.Fatalf("expected an empty, non-nil result, got %#v", results)
+	}
+}
+
+func testSearchWithFilter(t *testing.T, s rag.VectorStore) {
+	// The closest chunks belong to another customer; the filter must run
+	// before ranking so topK is still filled with acme's chunks.
+	var chunks []rag.Chunk
+	for i := range 20 {
+		ch := chunk(fmt.Sprintf("other-%d", i), "other", 1, float64(i)/100)
+		ch.Attributes = map[string]string{"customer": "globex"}
+		chunks = append(chunks, ch)
+	}
+	for i := range 3 {
+		ch := chunk(fmt.Sprintf("acme-%d", i), "acme", float64(i), 1)
+		ch.Attributes = map[string]string{"customer": "acme"}
+		chunks = append(chunks, ch)
+	}
+	mustAdd(t, s, chunks...)
+
+	acme := "acme"
+	filter := &rag.Filter{Field: "attr.customer", Eq: &acme}
+	results := s.SearchWith([]float64{1, 0}, 2, rag.SearchOptions{Filter: filter})
+	if fmt.Sprint(resultIDs(results)) != "[acme-2 acme-1]" {
+		t.Fatalf("expected [acme-2 acme-1], got %v", resultIDs(results))
+	}
+
+	if n := len(s.SearchWith([]float64{1, 0}, 10, rag.SearchOptions{})); n != 10 {
+		t.Fatalf("no filter: expected 10 results, got %d", n)
+	}
+	none := "nobody"
+	if n := len(s.SearchWith([]float64{1, 0}, 5, rag.SearchOptions{Filter: &rag.Filter{Field: "attr.customer", Eq: &none}})); n != 0 {
+		t.Fatalf("filter matching nothing: expected 0 results, got %d", n)
+	}
+}
+
+func testKeywordSearch(t *testing.T, s rag.VectorStore) {
+	texts := map[string]string{
+		"a": "Payment failed with error ERR-4021 at checkout.",
+		"b": "Checkout pages load slowly on mobile.",
+		"c": "Refunds are processed within five days.",
+	}
+	for _, id := range []string{"a", "b", "c"} {
+		ch := chunk(id, "faq", 1, 0)
+		ch.Content = texts[id]
+		mustAdd(t, s, ch)
+	}
+
+	if got := resultIDs(s.KeywordSearch("err-4021", 5, rag.SearchOptions{})); fmt.Sprint(got) != "[a]" {
+		t.Fatalf("identifier: expected [a], got %v", got)
+	}
+	if got := resultIDs(s.KeywordSearch("REFUND processing", 5, rag.SearchOptions{})); fmt.Sprint(got) != "[c]" {
+		t.Fatalf("case and stemming: expected [c], got %v", got)
+	}
+	if got := s.KeywordSearch("checkout", 5, rag.SearchOptions{}); len(got) != 2 || got[0].Score <= 0 {
+		t.Fatalf("expected 2 positive scores for checkout, got %+v", got)
+	}
+	if got := s.KeywordSearch("shipping", 5, rag.SearchOptions{}); len(got) != 0 {
+		t.Fatalf("expected no match, got %v", resultIDs(got))
+	}
+
+	if _, err := s.Delete("a"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if got := s.KeywordSearch("ERR-4021", 5, rag.SearchOptions{}); len(got) != 0 {
+		t.Fatalf("deleted chunk still matches: %v", resultIDs(got))
+	}
+	faq := "faq"
+	filter := &rag.Filter{Field: "source", In: []string{"other"}}
+	if got := s.KeywordSearch("checkout", 5, rag.SearchOptions{Filter: filter}); len(got) != 0 {
+		t.Fatalf("filter: expected no results, got %v", resultIDs(got))
+	}
+	filter = &rag.Filter{Field: "document_id", Eq: &faq}
+	if got := resultIDs(s.KeywordSearch("checkout", 5, rag.SearchOptions{Filter: filter})); fmt.Sprint(got) != "[b]" {
+		t.Fatalf("filter: expected [b], got %v", got)
+	}
+}
+
+func testDelete(t *testing.T, s rag.VectorStore) {
+	mustAdd(t, s, chunk("a", "d", 1, 0), chunk("b", "d", 0, 1), chunk("c", "d", 1, 1))
+
+	n, err := s.Delete("a", "c", "missing")
+	if err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if n != 2 {
+		t.Fatalf("expected 2 deleted chunks, got %d", n)
+	}
+	if fmt.Sprint(ids(s.List())) != "[b]" {
+		t.Fatalf("expected only [b] left, got %v", ids(s.List()))
+	}
+	for _, r := range s.Search([]float64{1, 0}, 10) {
+		if r.Chunk.ID != "b" {
+			t.Fatalf("deleted chunk %q still searchable", r.Chunk.ID)
+		}
+	}
+	if n, _ := s.Delete("a"); n != 0 {
+		t.Fatalf("deleting twice: expected 0, got %d", n)
+	}
+}
+
+func testDeleteDocument(t *testing.T, s rag.VectorStore) {
+	mustAdd(t, s, chunk("d1-0", "d1", 1, 0), chunk("d2-0", "d2", 0, 1), chunk("d1-1", "d1", 1, 1))
+
+	n, err := s.DeleteDocument("d1")
+	if err != nil {
+		t.Fatalf("DeleteDocument: %v", err)
+	}
+	if n != 2 {
+		t.Fatalf("expected 2 deleted chunks, got %d", n)
+	}
+	if fmt.Sprint(ids(s.List())) != "[d2-0]" {
+		t.Fatalf("expected only [d2-0] left, got %v", ids(s.List()))
+	}
+	if s.HasDocument("d1") {
+		t.Fatalf("HasDocument true after DeleteDocument")
+	}
+	if got := resultIDs(s.Search([]float64{1, 0}, 10)); fmt.Sprint(got) != "[d2-0]" {
+		t.Fatalf("deleted document still searchable: %v", got)
+	}
+	if n, _ := s.DeleteDocument("d1"); n != 0 {
+		t.Fatalf("deleting twice: expected 0, got %d", n)
+	}
+}
+
+func testDeleteSource(t *testing.T, s rag.VectorStore) {
+	a, b, c := chunk("a", "d1", 1, 0), chunk("b", "d2", 0, 1), chunk("c", "d3", 1, 1)
+	a.Source, b.Source, c.Source = "report.pdf", "report.pdf", "notes.md"
+	mustAdd(t, s, a, b, c)
+
+	n, err := s.DeleteSource("report.pdf")
+	if err != nil {
+		t.Fatalf("DeleteSource: %v", err)
+	}
+	if n != 2 {
+		t.Fatalf("expected 2 deleted chunks, got %d", n)
+	}
+	if fmt.Sprint(ids(s.List())) != "[c]" {
+		t.Fatalf("expected only [c] left, got %v", ids(s.List()))
+	}
+	if n, _ := s.DeleteSource("report.pdf"); n != 0 {
+		t.Fatalf("deleting twice: expected 0, got %d", n)
+	}
+}
+
+func testDocuments(t *testing.T, s rag.VectorStore) {
+	if docs := s.Documents(); docs == nil || len(docs) != 0 {
+		t.Fatalf("empty store: expected an empty, non-nil list, got %#v", docs)
+	}
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	b0, a0, a1 := chunk("b-0", "b", 0, 1), chunk("a-0", "a", 1, 0), chunk("a-1", "a", 1, 1)
+	b0.End = 40
+	a0.End, a1.Start, a1.End = 10, 11, 25
+	for _, ch := range []*rag.Chunk{&b0, &a0, &a1} {
+		ch.Model, ch.CreatedAt = "test-model", created
+	}
+	mustAdd(t, s, b0, a0, a1)
+
+	docs := s.Documents()
+	if len(docs) != 2 || docs[0].ID != "b" || docs[1].ID != "a" {
+		t.Fatalf("expected documents [b a] in upload order, got %+v", docs)
+	}
+	a := docs[1]
+	if a.ChunkCount != 2 || a.Bytes != 25 || a.Source != "a" || a.Model != "test-model" || !a.CreatedAt.Equal(created) {
+		t.Fatalf("unexpected summary for a: %+v", a)
+	}
+}
+
+func testDocumentChunksAndChunk(t *testing.T, s rag.VectorStore) {
+	c0, c1, c2 := chunk("d-0", "d", 1, 0), chunk("d-1", "d", 0, 1), chunk("d-2", "d", 1, 1)
+	c0.Index, c1.Index, c2.Index = 0, 1, 2
+	mustAdd(t, s, c2, chunk("other", "e", 1, 0), c0, c1)
+
+	if got := ids(s.DocumentChunks("d")); fmt.Sprint(got) != "[d-0 d-1 d-2]" {
+		t.Fatalf("expected chunks ordered by Index, got %v", got)
+	}
+	if got := s.DocumentChunks("missing"); len(got) != 0 {
+		t.Fatalf("expected no chunks for a missing document, got %v", ids(got))
+	}
+
+	ch, ok := s.Chunk("d-1")
+	if !ok || ch.ID != "d-1" || ch.DocumentID != "d" {
+		t.Fatalf("Chunk(d-1) = %+v, %v", ch, ok)
+	}
+	if _, ok := s.Chunk("missing"); ok {
+		t.Fatalf("expected Chunk(missing) not found")
+	}
+}
+
+func testClear(t *testing.T, s rag.VectorStore) {
+	mustAdd(t, s, chunk("a", "d", 1, 0))
+	if err := s.Clear(); err != nil {
+		t.Fatalf("Clear: %v", err)
+	}
+	if n := s.Count(); n != 0 {
+		t.Fatalf("expected Count 0 after Clear, got %d", n)
+	}
+	if n := len(s.Search([]float64{1, 0}, 3)); n != 0 {
+		t.Fatalf("expected no results after Clear, got %d", n)
+	}
+	mustAdd(t, s, chunk("b", "d", 1, 0))
+	if n := s.Count(); n != 1 {
+		t.Fatalf("store unusable after Clear: Count %d", n)
+	}
+}
+
+func testAddDocumentConflicts(t *testing.T, s rag.VectorStore) {
+	if _, err := s.AddDocument("doc", rag.ConflictReject, chunk("doc-1", "doc", 1, 0), chunk("doc-2", "doc", 0, 1)); err != nil {
+		t.Fatalf("AddDocument: %v", err)
+	}
+	mustAdd(t, s, chunk("other-1", "other", 1, 1))
+	if !s.HasDocument("doc") || s.HasDocument("nope") {
+		t.Fatalf("HasDocument reports the wrong documents")
+	}
+
+	_, err := s.AddDocument("doc", rag.ConflictReject, chunk("doc-1", "doc", 1, 1))
+	if !errors.Is(err, rag.ErrDocumentExists) {
+		t.Fatalf("expected ErrDocumentExists, got %v", err)
+	}
+	if n := s.Count(); n != 3 {
+		t.Fatalf("rejected upload changed the store: Count %d", n)
+	}
+
+	replaced, err := s.AddDocument("doc", rag.ConflictReplace, chunk("doc-1", "doc", 1, 1))
+	if err != nil {
+		t.Fatalf("AddDocument replace: %v", err)
+	}
+	if replaced != 2 {
+		t.Fatalf("expected 2 replaced chunks, got %d", replaced)
+	}
+	got := map[string]bool{}
+	for _, ch := range s.List() {
+		got[ch.ID] = true
+	}
+	if len(got) != 2 || !got["doc-1"] || !got["other-1"] {
+		t.Fatalf("expected [doc-1 other-1] after replace, got %v", ids(s.List()))
+	}
+}
+
+func testListReturnsCopy(t *testing.T, s rag.VectorStore) {
+	mustAdd(t, s, chunk("a", "d", 1, 0))
+	list := s.List()
+	list[0] = chunk("mutated", "d", 0, 1)
+	if s.List()[0].ID != "a" {
+		t.Fatalf("mutating List's result changed the store")
+	}
+}
+
+func testConcurrency(t *testing.T, s rag.VectorStore) {
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				id := fmt.Sprintf("w%d-%d", w, i)
+				if err := s.Add(chunk(id, "d", float64(w), float64(i))); err != nil {
+					t.Errorf("Add: %v", err)
+					return
+				}
+				s.Search([]float64{1, 1}, 5)
+				if i%10 == 0 {
+					s.Delete(id)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if n := s.Count(); n != 4*45 {
+		t.Fatalf("expected %d chunks after concurrent use, got %d", 4*45, n)
+	}
+}
@@ -0,0 +1,169 @@
+package rag
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// Tokenizer splits text into model tokens, returned as spans of the text.
+type Tokenizer interface {
+	Tokenize(text string) []Span
+}
+
+// ApproxTokenizer approximates OpenAI's BPE tokenizers without shipping
+// their merge tables or calling the network. It pre-tokenizes the way
+// cl100k does (words with their leading space, contractions, digit groups,
+// punctuation runs, newlines) and then splits long words into sub-word
+// pieces, which is what the BPE merges end up doing for rare words.
+// Counts are close to, not exactly, what the API bills.
+type ApproxTokenizer struct{}
+
+const (
+	// maxDigitsPerToken matches cl100k, which groups digits by three.
+	maxDigitsPerToken = 3
+	// maxRunesPerPiece is the typical length of a BPE piece inside a rare word.
+	maxRunesPerPiece = 5
+	// maxRunesPerWord is the longest word still counted as a single token.
+	maxRunesPerWord = 7
+)
+
+func (ApproxTokenizer) Tokenize(text string) []Span {
+	var tokens []Span
+	i := 0
+	for i < len(text) {
+		start := i
+		r, w := utf8.DecodeRuneInString(text[i:])
+
+		// A single space sticks to the word or punctuation that follows it.
+		if r == ' ' && i+w < len(text) {
+			next, _ := utf8.DecodeRuneInString(text[i+w:])
+			if !unicode.IsSpace(next) {
+				i += w
+				r, w = next, utf8.RuneLen(next)
+			}
+		}
+
+		contraction := 0
+		if r == '\'' {
+			contraction = contractionLen(text[i:])
+		}
+
+		switch {
+		case contraction > 0:
+			i += contraction
+			tokens = append(tokens, Span{start, i})
+		case unicode.IsLetter(r):
+			end := scan(text, i, unicode.IsLetter)
+			tokens = appendWordPieces(tokens, text, start, i, end)
+			i = end
+		case unicode.IsDigit(r):
+			end := scan(text, i, unicode.IsDigit)
+			for p := i; p < end; {
+				n := min(maxDigitsPerToken, end-p)
+				from := p
+				if p == i {
+					from = start
+				}
+				tokens = append(tokens, Span{from, p + n})
+				p += n
+			}
+			i = end
+		case unicode.IsSpace(r):
+			i = scan(text, i, unicode.IsSpace)
+			// Leave the last space of a run to the word that follows.
+			if i < len(text) && i-start > 1 && text[i-1] == ' ' {
+				i--
+			}
+			tokens = append(tokens, Span{start, i})
+		default:
+			i = scan(text, i, isPunct)
+			tokens = append(tokens, Span{start, i})
+		}
+	}
+	return tokens
+}
+
+// CountTokens returns the approximate number of tokens in text.
+func CountTokens(text string) int {
+	return len(ApproxTokenizer{}.Tokenize(text))
+}
+
+// appendWordPieces emits a letter run text[wordStart:end] (with an optional
+// leading space starting at start) as one token when short, or as sub-word
+// pieces when long. Scripts without spaces between words (CJK, Thai, ...)
+// cost roughly one token per character.
+func appendWordPieces(tokens []Span, text string, start, wordStart, end int) []Span {
+	if utf8.RuneCountInString(text[wordStart:end]) <= maxRunesPerWord && !isDenseScript(text[wordStart:end]) {
+		return append(tokens, Span{start, end})
+	}
+	from, n := start, 0
+	for p := wordStart; p < end; {
+		r, w := utf8.DecodeRuneInString(text[p:])
+		p += w
+		n++
+		if n == maxRunesPerPiece || isDenseRune(r) || p == end {
+			tokens = append(tokens, Span{from, p})
+			from, n = p, 0
+		}
+	}
+	return tokens
+}
+
+func isDenseScript(s string) bool {
+	for _, r := range s {
+		if isDenseRune(r) {
+			return true
+		}
+	}
+	return false
+}
+
+func isDenseRune(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul, unicode.Thai)
+}
+
+func isPunct(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.IsSpace(r)
+}
+
+// scan returns the end of the run of runes matching fn starting at i.
+func scan(text string, i int, fn func(rune) bool) int {
+	for i < len(text) {
+		r, w := utf8.DecodeRuneInString(text[i:])
+		if !fn(r) {
+			break
+		}
+		i += w
+	}
+	return i
+}
+
+// contractionLen returns the length of an English contraction suffix
+// ('s, 't, 're, 've, 'm, 'll, 'd) at the start of s, or 0.
+func contractionLen(s string) int {
+	for _, c := range []string{"'s", "'t", "'re", "'ve", "'m", "'ll", "'d"} {
+		if len(s) >= len(c) && equalFoldASCII(s[:len(c)], c) {
+			if len(s) == len(c) {
+				return len(c)
+			}
+			next, _ := utf8.DecodeRuneInString(s[len(c):])
+			if !unicode.IsLetter(next) {
+				return len(c)
+			}
+		}
+	}
+	return 0
+}
+
+func equalFoldASCII(a, b string) bool {
+	for i := 0; i < len(a); i++ {
+		x, y := a[i], b[i]
+		if 'A' <= x && x <= 'Z' {
+			x += 'a' - 'A'
+		}
+		if x != y {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,52 @@
+package rag
+
+import (
+	"strings"
+	"testing"
+)
+
+func tokenTexts(text string) []string {
+	var out []string
+	for _, tok := range (ApproxTokenizer{}).Tokenize(text) {
+		out = append(out, text[tok.Start:tok.End])
+	}
+	return out
+}
+
+func TestApproxTokenizer_Pieces(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"Hello world", []string{"Hello", " world"}},
+		{"I don't know.", []string{"I", " don", "'t", " know", "."}},
+		{"pay 1234567 now", []string{"pay", " 123", "456", "7", " now"}},
+		{"a  b\n\nc", []string{"a", " ", " b", "\n\n", "c"}},
+		{"internationalization", []string{"inter", "natio", "naliz", "ation"}},
+		{"日本語", []string{"日", "本", "語"}},
+	}
+
+	for _, tc := range tests {
+		got := tokenTexts(tc.text)
+		if strings.Join(got, "|") != strings.Join(tc.want, "|") {
+			t.Fatalf("Tokenize(%q) = %q, want %q", tc.text, got, tc.want)
+		}
+	}
+}
+
+func TestApproxTokenizer_CoversText(t *testing.T) {
+	text := "Go's tokenizer: v1.12.0, e.g. 3.14 — naïve café!\n\tDone?"
+	var b strings.Builder
+	for _, piece := range tokenTexts(text) {
+		b.WriteString(piece)
+	}
+	if b.String() != text {
+		t.Fatalf("tokens do not reassemble the text: %q", b.String())
+	}
+}
+
+func TestCountTokens_Empty(t *testing.T) {
+	if n := CountTokens(""); n != 0 {
+		t.Fatalf("expected 0 tokens, got %d", n)
+	}
+}
@@ -1,15 +1,44 @@
 package rag
 
+import "time"
+
+// Span is a range of a text, as byte offsets: text[Start:End].
+// Tokenizers and sentence segmentation both report their pieces this way.
+type Span struct {
+	Start int
+	End   int
+}
+
 // Chunk of a document
 type Chunk struct {
-	ID        string
-	Content   string
-	Source    string // filename or doc ID
-	Embedding []float64
+	ID         string
+	DocumentID string
+	Index      int // position within the document, from 0
+	Content    string
+	Source     string // filename or doc ID
+	Embedding  []float64
+	Model      string            `json:",omitempty"` // embedder model that produced Embedding
+	Metadata   map[string]string `json:",omitempty"` // set by the chunker, e.g. heading_path
+	Attributes map[string]string `json:",omitempty"` // set by the uploader
+
+	// Byte offsets of Content within the document text.
+	Start int
+	End   int
+	// Page is the 1-based page Content starts on, for PDFs.
+	Page int `json:",omitempty"`
+	// Line range within Source, for source code chunks.
+	StartLine int `json:",omitempty"`
+	EndLine   int `json:",omitempty"`
+
+	CreatedAt time.Time
 }
 
 // Simple query result
 type SearchResult struct {
-	Chunk    Chunk
-	Score    float64
-}
\ No newline at end of file
+	Chunk      Chunk
+	Score      float64
+	Collection string `json:",omitempty"` // set when searching collections
+	// Ranks holds the 1-based rank the chunk had in each retriever's list
+	// (RetrieverVector, RetrieverKeyword), set by Fuse.
+	Ranks map[string]int `json:",omitempty"`
+}
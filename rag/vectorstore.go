@@ -0,0 +1,83 @@
+package rag
+
+// VectorStore holds embedded chunks and finds the ones closest to a query.
+// The server only talks to this interface, so backends can be swapped
+// without touching the HTTP layer. Implementations must be safe for
+// concurrent use; storetest.Run checks the expected behaviour.
+type VectorStore interface {
+	// Add stores chunks as they are, without any document-level checks. A
+	// chunk whose ID is already stored replaces the old one everywhere.
+	Add(chunks ...Chunk) error
+	// AddDocument stores the chunks of one document, applying the conflict
+	// policy when the document ID is already present. Replacing must be
+	// atomic: a concurrent Search sees either the old or the new chunks.
+	// It returns how many old chunks were replaced.
+	AddDocument(docID string, policy ConflictPolicy, chunks ...Chunk) (int, error)
+	// HasDocument reports whether any chunk of the document is stored.
+	HasDocument(docID string) bool
+
+	// Search returns the topK chunks most similar to the query by cosine
+	// similarity, best first. topK <= 0 returns nothing.
+	Search(queryEmbedding []float64, topK int) []SearchResult
+	// SearchWith is Search with options. A filter is applied before
+	// ranking, so topK is filled with matching chunks when there are
+	// enough of them.
+	SearchWith(queryEmbedding []float64, topK int, opts SearchOptions) []SearchResult
+	// KeywordSearch returns the topK chunks best matching the words of
+	// the query by BM25, best first; chunks sharing no term with it are
+	// left out. Options apply as in SearchWith.
+	KeywordSearch(query string, topK int, opts SearchOptions) []SearchResult
+
+	// Delete removes chunks by ID and returns how many were found.
+	Delete(ids ...string) (int, error)
+	// DeleteDocument removes every chunk of a document and returns how
+	// many were found.
+	DeleteDocument(docID string) (int, error)
+	// DeleteSource removes every chunk with the given Source, across
+	// documents, and returns how many were found.
+	DeleteSource(source string) (int, error)
+	// Clear removes every chunk.
+	Clear() error
+	// Count returns the number of stored chunks.
+	Count() int
+	// List returns every stored chunk in insertion order.
+	List() []Chunk
+
+	// Documents summarizes the stored documents, in the order their first
+	// chunk was added.
+	Documents() []DocumentInfo
+	// DocumentChunks returns the chunks of one document ordered by Index,
+	// or nil if there are none.
+	DocumentChunks(docID string) []Chunk
+	// Chunk looks a chunk up by ID.
+	Chunk(id string) (Chunk, bool)
+}
+
+var _ VectorStore = (*InMemoryStore)(nil)
+
+// Counter is implemented by stores that can count the chunks a query
+// matches without ranking them, so a caller can report a total next to
+// one page of results.
+type Counter interface {
+	// CountMatches returns how many chunks match q. ok is false when the
+	// store cannot count them without the full scan its index avoids.
+	CountMatches(q CountQuery) (n int, ok bool)
+}
+
+// CountQuery describes the chunks CountMatches counts: those passing
+// Filter that are close enough to Embedding or match the words of Text,
+// as SearchWith and KeywordSearch would find them.
+type CountQuery struct {
+	// Embedding, when set, matches chunks with a cosine similarity of at
+	// least MinScore.
+	Embedding []float64
+	MinScore  float64
+	// Text, when set, matches chunks sharing a term with it whose BM25
+	// score is at least MinKeywordScore.
+	Text            string
+	MinKeywordScore float64
+
+	Filter *Filter
+}
+
+var _ Counter = (*InMemoryStore)(nil)